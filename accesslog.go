@@ -0,0 +1,310 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	// LogCombined is the combined log format used by Apache and nginx,
+	// followed by the matched short URL, destination, handler, and
+	// latency in seconds.
+	LogCombined = "combined"
+
+	// LogJSON writes a JSON object for each request on its own line.
+	LogJSON = "json"
+)
+
+// An AccessLog records the requests served by a Server.
+type AccessLog struct {
+	// Format is the format of log entries, LogCombined or LogJSON.
+	Format string
+
+	// TrustedProxies are the networks of proxies, such as nginx, whose
+	// X-Forwarded-For headers are trusted to identify the client.
+	TrustedProxies []*net.IPNet
+
+	// mutex guards the fields below, and serializes writes
+	mutex sync.Mutex
+	w     io.Writer
+	path  string
+	file  *os.File
+}
+
+// NewAccessLog constructs a new AccessLog which writes to w in the specified
+// format.
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	if format != LogCombined && format != LogJSON {
+		return nil, fmt.Errorf("gum: unknown access log format %q", format)
+	}
+	return &AccessLog{Format: format, w: w}, nil
+}
+
+// OpenAccessLog constructs a new AccessLog which appends to the file at path
+// in the specified format, creating it if it does not exist.
+func OpenAccessLog(path, format string) (*AccessLog, error) {
+	l, err := NewAccessLog(nil, format)
+	if err != nil {
+		return nil, err
+	}
+	l.path = path
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reopen closes and reopens the file of an AccessLog opened with
+// OpenAccessLog, such as after the file has been rotated.  For other access
+// logs, Reopen does nothing.
+func (l *AccessLog) Reopen() error {
+	if l.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("gum: error opening access log: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	old := l.file
+	l.file, l.w = f, f
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close closes the file of an AccessLog opened with OpenAccessLog.
+func (l *AccessLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file, l.w = nil, ioutil.Discard
+	return err
+}
+
+// ParseNetworks parses a list of IP addresses and CIDR networks, such as
+// "127.0.0.1" or "10.0.0.0/8", for use as TrustedProxies.
+func ParseNetworks(networks []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range networks {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("gum: invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("gum: invalid network %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trusted reports whether ip is in one of the trusted proxy networks.
+func (l *AccessLog) trusted(ip net.IP) bool {
+	for _, n := range l.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client which sent r.  If the request
+// came from a trusted proxy, the X-Forwarded-For header is used, skipping any
+// further trusted proxies from the right.
+func (l *AccessLog) clientIP(r *http.Request) string {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	ip := net.ParseIP(client)
+	if ip == nil || !l.trusted(ip) {
+		return client
+	}
+
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			// the header is malformed, so stop at the last proxy
+			break
+		}
+		client = addr
+		if !l.trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// accessKey is the context key of the accessEntry of a request.
+type accessKey struct{}
+
+// accessEntry describes how a request was served, for the access log.
+type accessEntry struct {
+	// handler is the kind of handler which served the request
+	handler string
+	// match is the short URL or handler pattern which matched the request
+	match string
+}
+
+// setAccessEntry records how a request served by a handler of a Server was
+// served, as identified by the request context.
+func setAccessEntry(r *http.Request, handler, match string) {
+	if e, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+		e.handler, e.match = handler, match
+	}
+}
+
+// handlerName returns the kind of handler h, for the access log.
+func handlerName(h http.Handler) string {
+	switch h.(type) {
+	case *RedirectHandler:
+		return "redirect"
+	case *RuleHandler:
+		return "rule"
+	}
+	return "handler"
+}
+
+// logRequest writes an entry to l for request r, whose response was recorded
+// by rec.
+func (l *AccessLog) logRequest(r *http.Request, rec *responseRecorder, e accessEntry, start time.Time) {
+	latency := time.Since(start)
+	client := l.clientIP(r)
+	dest := rec.Header().Get("Location")
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var line []byte
+	if l.Format == LogJSON {
+		line, _ = json.Marshal(struct {
+			Time        string  `json:"time"`
+			Client      string  `json:"client_ip"`
+			Method      string  `json:"method"`
+			Host        string  `json:"host"`
+			URI         string  `json:"uri"`
+			Proto       string  `json:"proto"`
+			Status      int     `json:"status"`
+			Bytes       int64   `json:"bytes"`
+			Referer     string  `json:"referer,omitempty"`
+			UserAgent   string  `json:"user_agent,omitempty"`
+			ShortPath   string  `json:"short_path,omitempty"`
+			Destination string  `json:"destination,omitempty"`
+			Handler     string  `json:"handler"`
+			Latency     float64 `json:"latency_seconds"`
+		}{
+			Time:        start.UTC().Format(time.RFC3339Nano),
+			Client:      client,
+			Method:      r.Method,
+			Host:        r.Host,
+			URI:         r.RequestURI,
+			Proto:       r.Proto,
+			Status:      status,
+			Bytes:       rec.bytes,
+			Referer:     r.Referer(),
+			UserAgent:   r.UserAgent(),
+			ShortPath:   e.match,
+			Destination: dest,
+			Handler:     e.handler,
+			Latency:     latency.Seconds(),
+		})
+		line = append(line, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %d %q %q %q %q %s %.6f\n",
+			client, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, status, rec.bytes,
+			dash(r.Referer()), dash(r.UserAgent()),
+			dash(e.match), dash(dest), e.handler, latency.Seconds()))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.w != nil {
+		l.w.Write(line)
+	}
+}
+
+// dash returns s, or "-" if s is empty, as in the combined log format.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// responseRecorder is an http.ResponseWriter which records the status and
+// size of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, if the underlying ResponseWriter does.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// SetAccessLog sets the access log that requests served by s are written to.
+// If l is nil, requests are not logged.
+func (s *Server) SetAccessLog(l *AccessLog) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accessLog = l
+}
@@ -0,0 +1,172 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog_clientIP(t *testing.T) {
+	proxies, err := ParseNetworks([]string{"127.0.0.1", "10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("ParseNetworks returned error: %v", err)
+	}
+	l := &AccessLog{TrustedProxies: proxies}
+
+	tests := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		// forwarded headers from untrusted clients are ignored
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"127.0.0.1:1234", nil, "127.0.0.1"},
+		{"127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"[::1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// trusted proxies are skipped from the right
+		{"127.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"203.0.113.1", "198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"127.0.0.1:1234", []string{"10.1.2.3"}, "10.1.2.3"},
+		// malformed addresses stop at the last proxy
+		{"127.0.0.1:1234", []string{"198.51.100.1, bogus, 10.1.2.3"}, "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		for _, f := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+		if got := l.clientIP(req); got != tt.want {
+			t.Errorf("clientIP(%q, %q) returned %q, want %q", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestParseNetworks_Error(t *testing.T) {
+	for _, s := range []string{"bogus", "10.0.0.0/33"} {
+		if _, err := ParseNetworks([]string{s}); err == nil {
+			t.Errorf("ParseNetworks(%q) did not return expected error", s)
+		}
+	}
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	g.AddMapping(Mapping{ShortPath: "/g", Status: 410})
+	rh, _ := NewRedirectHandler("w", "https://en.wikipedia.org/wiki/")
+	rule, _ := NewRule("/i/{id:[0-9]+}", "/issues/{id}")
+	if err := g.Reload(rh, NewRuleHandler(rule)); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	l, err := NewAccessLog(&buf, LogJSON)
+	if err != nil {
+		t.Fatalf("NewAccessLog returned error: %v", err)
+	}
+	g.SetAccessLog(l)
+
+	type entry struct {
+		Status      int    `json:"status"`
+		ShortPath   string `json:"short_path"`
+		Destination string `json:"destination"`
+		Handler     string `json:"handler"`
+	}
+	tests := []struct {
+		url  string
+		want entry
+	}{
+		{"/a", entry{301, "/a", "/pa", "mapping"}},
+		{"/g", entry{410, "/g", "", "gone"}},
+		{"/w/Gum", entry{301, "/w/", "https://en.wikipedia.org/wiki/Gum", "redirect"}},
+		{"/i/1", entry{301, "/i/{id:[0-9]+}", "/issues/1", "rule"}},
+		{"/i/x", entry{404, "", "", "not_found"}},
+		{"/nope", entry{404, "", "", "not_found"}},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.url, nil))
+		var got entry
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("%s: access log entry %q is not valid JSON: %v", tt.url, buf.String(), err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: access log entry is %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestServer_AccessLog_Combined(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+
+	var buf bytes.Buffer
+	l, _ := NewAccessLog(&buf, LogCombined)
+	g.SetAccessLog(l)
+
+	req := httptest.NewRequest("GET", "/a?x=1", nil)
+	req.Header.Set("Referer", "https://example.org/")
+	req.Header.Set("User-Agent", "test \"agent\"")
+	g.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d\d/\w{3}/\d{4}:\d\d:\d\d:\d\d [-+]\d{4}\] "GET /a\?x=1 HTTP/1\.1" 301 \d+ "https://example\.org/" "test \\"agent\\"" "/a" "/pa" mapping \d+\.\d{6}\n$`)
+	if got := buf.String(); !want.MatchString(got) {
+		t.Errorf("access log entry is %q, want match for %v", got, want)
+	}
+}
+
+func TestOpenAccessLog_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := OpenAccessLog(path, LogCombined)
+	if err != nil {
+		t.Fatalf("OpenAccessLog returned error: %v", err)
+	}
+	defer l.Close()
+
+	g := NewServer()
+	g.SetAccessLog(l)
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+
+	// rotate the log, as logrotate would
+	rotated := filepath.Join(dir, "access.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("Reopen returned error: %v", err)
+	}
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/b", nil))
+
+	for p, want := range map[string]string{rotated: "GET /a ", path: "GET /b "} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); strings.Count(got, "\n") != 1 || !strings.Contains(got, want) {
+			t.Errorf("%s contains %q, want one entry for %q", filepath.Base(p), got, want)
+		}
+	}
+}
+
+func TestNewAccessLog_Format(t *testing.T) {
+	if _, err := NewAccessLog(ioutil.Discard, "common"); err == nil {
+		t.Errorf("NewAccessLog did not return expected error for unknown format")
+	}
+}
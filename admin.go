@@ -0,0 +1,427 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves a JSON API for managing the mappings and redirect
+// handlers of a Server at runtime.  Every request must include the handler's
+// token as a bearer token in the Authorization header.  The API consists of:
+//
+//     GET    /api/mappings                    list all mappings
+//     GET    /api/mappings?host=h&path=/x     get a single mapping
+//     POST   /api/mappings                    create a mapping
+//     PUT    /api/mappings                    create or update a mapping
+//     DELETE /api/mappings?host=h&path=/x     delete a mapping
+//     GET    /api/conflicts                   list conflicting mappings
+//     GET    /api/redirects                   list redirect handlers
+//     POST   /api/redirects                   create a redirect handler
+//     DELETE /api/redirects?host=h&prefix=x   delete a redirect handler
+//     POST   /api/generate                    create a mapping with a generated short path
+//     GET    /api/shorten?url=u&host=h        list short URLs redirecting to a permalink
+//     GET    /api/clicks?path=/x&from=d&to=d  list click counts
+//     GET    /api/export?format=f             export all redirects
+//     GET    /metrics                         Prometheus metrics
+//
+// Mappings are represented as JSON objects with "host", "short_path",
+// "permalink", "status", "source", "query", and "title" fields.  Mappings
+// created through the API are manual mappings (see SourceManual), and only
+// manual mappings and tombstones can be deleted.  Redirect handlers are
+// represented as JSON objects with "host", "prefix", "destination", "status",
+// and "query" fields.  Requests to generate a mapping are JSON objects with
+// "permalink" and optional "host" fields.  Clicks are JSON objects with
+// "host", "short_path", "referrer", "day", and "count" fields, and can be
+// filtered by "host", "path", and a range of days.  Short URLs for a
+// permalink are listed as mappings, and can be filtered to those which apply
+// to a host.
+//
+// Redirects are exported in one of ExportFormats (see Server.Export), which
+// defaults to JSON.
+//
+// Metrics for the server are served at /metrics in the Prometheus text
+// exposition format (see Server.WriteMetrics), and likewise require the token.
+type AdminHandler struct {
+	// Generator is used to generate short paths for new mappings.
+	Generator Generator
+
+	server *Server
+	token  string
+	mux    *http.ServeMux
+}
+
+// NewAdminHandler constructs a new AdminHandler for s, which requires requests
+// to be authenticated with token.  If token is empty, all requests are
+// rejected.
+func NewAdminHandler(s *Server, token string) *AdminHandler {
+	h := &AdminHandler{
+		server: s,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/api/mappings", h.serveMappings)
+	h.mux.HandleFunc("/api/conflicts", h.serveConflicts)
+	h.mux.HandleFunc("/api/redirects", h.serveRedirects)
+	h.mux.HandleFunc("/api/generate", h.serveGenerate)
+	h.mux.HandleFunc("/api/shorten", h.serveShorten)
+	h.mux.HandleFunc("/api/clicks", h.serveClicks)
+	h.mux.HandleFunc("/api/export", h.serveExport)
+	h.mux.HandleFunc("/metrics", h.serveMetrics)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gum"`)
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r includes the correct bearer token.
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if h.token == "" || !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(h.token)) == 1
+}
+
+func (h *AdminHandler) serveMappings(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	host, path := query.Get("host"), query.Get("path")
+
+	switch r.Method {
+	case http.MethodGet:
+		if path == "" {
+			writeJSON(w, http.StatusOK, h.server.Mappings())
+			return
+		}
+		m, ok := h.server.get(Mapping{Host: host, ShortPath: path}.key())
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+
+	case http.MethodPost, http.MethodPut:
+		var m Mapping
+		if err := decodeJSON(w, r, &m); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid mapping: %w", err))
+			return
+		}
+		m.Source = Source{Kind: SourceManual}
+
+		var err error
+		if r.Method == http.MethodPost {
+			err = h.server.AddMapping(m)
+		} else {
+			err = h.server.SetMapping(m)
+		}
+		var cerr *ConflictError
+		if errors.As(err, &cerr) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":    err.Error(),
+				"existing": cerr.Existing,
+			})
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		m, _ = h.server.get(m.key())
+		writeJSON(w, http.StatusOK, m)
+
+	case http.MethodDelete:
+		m := Mapping{Host: host, ShortPath: path, Source: Source{Kind: SourceManual}}
+		if err := h.server.removeMapping(m); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		} else if existing, ok := h.server.get(m.key()); ok && existing.isTombstone() {
+			// tombstones are only served if no other source maps
+			// the short URL, so they can be removed entirely.
+			if err := h.server.RemoveMapping(host, path); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		} else if ok {
+			writeError(w, http.StatusConflict, fmt.Errorf("mapping is provided by %v and cannot be deleted", existing.Source))
+		} else {
+			writeError(w, http.StatusNotFound, err)
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (h *AdminHandler) serveConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	conflicts := h.server.Conflicts()
+	if conflicts == nil {
+		conflicts = []Conflict{}
+	}
+	writeJSON(w, http.StatusOK, conflicts)
+}
+
+func (h *AdminHandler) serveShorten(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query := r.URL.Query()
+	permalink, host := query.Get("url"), strings.ToLower(query.Get("host"))
+	if permalink == "" {
+		writeError(w, http.StatusBadRequest, errors.New("url must be specified"))
+		return
+	}
+
+	mappings := []Mapping{}
+	for _, m := range h.server.ShortURLs(permalink) {
+		if host == "" || m.Host == "" || m.Host == host {
+			mappings = append(mappings, m)
+		}
+	}
+	if len(mappings) == 0 {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, mappings)
+}
+
+func (h *AdminHandler) serveGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var req struct {
+		Host      string `json:"host"`
+		Permalink string `json:"permalink"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Permalink == "" {
+		writeError(w, http.StatusBadRequest, errors.New("permalink must be specified"))
+		return
+	}
+
+	m, err := h.Generator.Generate(h.server, req.Host, req.Permalink)
+	if errors.Is(err, ErrNoCode) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// redirectJSON is the JSON representation of a RedirectHandler.
+type redirectJSON struct {
+	Host        string      `json:"host,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Destination string      `json:"destination"`
+	Status      int         `json:"status"`
+	Query       QueryPolicy `json:"query,omitempty"`
+}
+
+func (h *AdminHandler) serveRedirects(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		redirects := []redirectJSON{}
+		for _, rh := range h.redirectHandlers() {
+			redirects = append(redirects, redirectJSON{
+				Host:        rh.Host,
+				Prefix:      rh.Prefix,
+				Destination: rh.Destination.String(),
+				Status:      rh.Status,
+				Query:       rh.Query,
+			})
+		}
+		writeJSON(w, http.StatusOK, redirects)
+
+	case http.MethodPost:
+		var rj redirectJSON
+		if err := decodeJSON(w, r, &rj); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid redirect: %w", err))
+			return
+		}
+		rh, err := NewRedirectHandler(rj.Prefix, rj.Destination)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		rh.Host = rj.Host
+		rh.Query = rj.Query
+		if rj.Status != 0 {
+			rh.Status = rj.Status
+		}
+		if err := rh.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if h.findRedirect(rh.Host, rh.Prefix) != nil {
+			writeError(w, http.StatusConflict, fmt.Errorf("redirect handler already exists for %v", rh.Host+"/"+rh.Prefix))
+			return
+		}
+		if err := h.server.AddHandler(rh); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		rj.Destination, rj.Status = rh.Destination.String(), rh.Status
+		writeJSON(w, http.StatusOK, rj)
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		rh := h.findRedirect(query.Get("host"), query.Get("prefix"))
+		if rh == nil {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		if err := h.server.RemoveHandler(rh); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// redirectHandlers returns the RedirectHandlers of the server.
+func (h *AdminHandler) redirectHandlers() []*RedirectHandler {
+	var handlers []*RedirectHandler
+	for _, sh := range h.server.Handlers() {
+		if rh, ok := sh.(*RedirectHandler); ok {
+			handlers = append(handlers, rh)
+		}
+	}
+	return handlers
+}
+
+// findRedirect returns the server's RedirectHandler for host and prefix, or
+// nil if there is none.
+func (h *AdminHandler) findRedirect(host, prefix string) *RedirectHandler {
+	for _, rh := range h.redirectHandlers() {
+		if strings.EqualFold(rh.Host, host) && rh.Prefix == prefix {
+			return rh
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) serveClicks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	t := h.server.ClickTracker()
+	if t == nil {
+		writeError(w, http.StatusNotFound, errors.New("click tracking is not enabled"))
+		return
+	}
+
+	query := r.URL.Query()
+	f := ClickFilter{
+		Host:      query.Get("host"),
+		ShortPath: query.Get("path"),
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+	}
+	for _, day := range []string{f.From, f.To} {
+		if _, err := time.Parse(dayFormat, day); day != "" && err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid day %q, should be of the form YYYY-MM-DD", day))
+			return
+		}
+	}
+	clicks, err := t.Clicks(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, clicks)
+}
+
+// exportContentTypes are the content types of exports, keyed by format.
+var exportContentTypes = map[string]string{
+	ExportJSON: "application/json; charset=utf-8",
+	ExportCSV:  "text/csv; charset=utf-8",
+}
+
+func (h *AdminHandler) serveExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportJSON
+	}
+	var buf bytes.Buffer
+	if err := h.server.Export(&buf, format); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+func (h *AdminHandler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.server.WriteMetrics(w); err != nil {
+		log.Printf("error writing metrics: %v", err)
+	}
+}
+
+// maxRequestBytes is the largest request body accepted by the admin API.
+const maxRequestBytes = 1 << 20
+
+// decodeJSON decodes the JSON body of r into v, reading at most
+// maxRequestBytes.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(v)
+}
+
+// writeJSON writes v to w as JSON with the specified status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes err to w as a JSON object with the specified status code.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
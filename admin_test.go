@@ -0,0 +1,318 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to h with the specified method, URL, and body,
+// authenticated with token.
+func adminRequest(h http.Handler, token, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestAdminHandler_Auth(t *testing.T) {
+	g := NewServer()
+
+	tests := []struct {
+		handlerToken, requestToken string
+		code                       int
+	}{
+		{"secret", "secret", http.StatusOK},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "wrong", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		h := NewAdminHandler(g, tt.handlerToken)
+		resp := adminRequest(h, tt.requestToken, "GET", "/api/mappings", "")
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("request with token %q to handler with token %q returned status %v, want %v",
+				tt.requestToken, tt.handlerToken, got, want)
+		}
+	}
+}
+
+func TestAdminHandler_Mappings(t *testing.T) {
+	g := NewServer()
+	h := NewAdminHandler(g, "t")
+
+	static := Mapping{ShortPath: "/s", Permalink: "/static", Source: Source{Kind: SourceStatic, Name: "site"}}
+	if err := g.AddMapping(static); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+	tombstone := Mapping{ShortPath: "/t", Permalink: "/retired"}.tombstone(time.Now())
+	if err := g.AddMapping(tombstone); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+
+	tests := []struct {
+		method, url, body string
+		code              int
+		permalink         string // expected permalink of /a after request
+	}{
+		{"POST", "/api/mappings", `{"short_path": "/a", "permalink": "/1"}`, http.StatusOK, "/1"},
+		{"POST", "/api/mappings", `{"short_path": "/a", "permalink": "/2"}`, http.StatusConflict, "/1"},
+		{"POST", "/api/mappings", `{"short_path": "a", "permalink": "/2"}`, http.StatusBadRequest, "/1"},
+		{"POST", "/api/mappings", `{`, http.StatusBadRequest, "/1"},
+		{"PUT", "/api/mappings", `{"short_path": "/a", "permalink": "/` + strings.Repeat("x", maxRequestBytes) + `"}`, http.StatusBadRequest, "/1"},
+		{"PUT", "/api/mappings", `{"short_path": "/a", "permalink": "/2"}`, http.StatusOK, "/2"},
+		{"GET", "/api/mappings?path=/a", "", http.StatusOK, "/2"},
+		{"GET", "/api/mappings?path=/b", "", http.StatusNotFound, "/2"},
+		{"DELETE", "/api/mappings?path=/s", "", http.StatusConflict, "/2"},
+		{"DELETE", "/api/mappings?path=/t", "", http.StatusNoContent, "/2"},
+		{"DELETE", "/api/mappings?path=/a", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/mappings?path=/a", "", http.StatusNotFound, ""},
+		{"PATCH", "/api/mappings", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", tt.method, tt.url, tt.body)
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("%v %v returned status %v, want %v: %s", tt.method, tt.url, got, want, resp.Body)
+		}
+		m, _ := g.Lookup("", "/a")
+		if got, want := m.Permalink, tt.permalink; got != want {
+			t.Errorf("after %v %v, /a has permalink %q, want %q", tt.method, tt.url, got, want)
+		}
+	}
+
+	// listing mappings includes their source
+	resp := adminRequest(h, "t", "GET", "/api/mappings", "")
+	var mappings []Mapping
+	if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+		t.Fatalf("error decoding mappings: %v", err)
+	}
+	if len(mappings) != 1 || mappings[0] != static {
+		t.Errorf("GET /api/mappings returned %v, want [%v]", mappings, static)
+	}
+}
+
+func TestAdminHandler_Redirects(t *testing.T) {
+	g := NewServer()
+	h := NewAdminHandler(g, "t")
+
+	tests := []struct {
+		method, url, body string
+		code              int
+		location          string // expected Location for /x/y after request
+	}{
+		{"POST", "/api/redirects", `{"prefix": "x", "destination": "http://example/"}`, http.StatusOK, "http://example/y"},
+		{"POST", "/api/redirects", `{"prefix": "x", "destination": "http://other/"}`, http.StatusConflict, "http://example/y"},
+		{"POST", "/api/redirects", `{"prefix": "z", "destination": "/", "status": 200}`, http.StatusBadRequest, "http://example/y"},
+		{"POST", "/api/redirects", `{"prefix": "/z/", "destination": "/"}`, http.StatusBadRequest, "http://example/y"},
+		{"DELETE", "/api/redirects?prefix=x", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/redirects?prefix=x", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", tt.method, tt.url, tt.body)
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("%v %v returned status %v, want %v: %s", tt.method, tt.url, got, want, resp.Body)
+		}
+
+		resp = httptest.NewRecorder()
+		g.ServeHTTP(resp, httptest.NewRequest("GET", "/x/y", nil))
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("after %v %v, /x/y redirects to %q, want %q", tt.method, tt.url, got, want)
+		}
+	}
+}
+
+func TestAdminHandler_Generate(t *testing.T) {
+	g := NewServer()
+	h := NewAdminHandler(g, "t")
+	h.Generator = Generator{Alphabet: "ab", Length: 1}
+
+	tests := []struct {
+		method, body string
+		code         int
+	}{
+		{"POST", `{"permalink": "/1"}`, http.StatusOK},
+		{"POST", `{"permalink": "/2"}`, http.StatusOK},
+		{"POST", `{"permalink": "/3"}`, http.StatusServiceUnavailable},
+		{"POST", `{"host": "x.example"}`, http.StatusBadRequest},
+		{"POST", `{`, http.StatusBadRequest},
+		{"GET", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", tt.method, "/api/generate", tt.body)
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("%v %s returned status %v, want %v: %s", tt.method, tt.body, got, want, resp.Body)
+		}
+		if resp.Code != http.StatusOK {
+			continue
+		}
+		var m Mapping
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Fatalf("error decoding mapping: %v", err)
+		}
+		if got, _ := g.Lookup("", m.ShortPath); got != m {
+			t.Errorf("%v %s returned %v, but server has %v", tt.method, tt.body, m, got)
+		}
+	}
+}
+
+func TestAdminHandler_Shorten(t *testing.T) {
+	g := NewServer()
+	h := NewAdminHandler(g, "t")
+	for _, m := range []Mapping{
+		{ShortPath: "/a", Permalink: "https://example.com/a"},
+		{Host: "s.example", ShortPath: "/b", Permalink: "https://example.com/a"},
+		{Host: "t.example", ShortPath: "/c", Permalink: "https://example.com/a"},
+	} {
+		if err := g.AddMapping(m); err != nil {
+			t.Fatalf("AddMapping returned error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		method, url string
+		code        int
+		paths       []string
+	}{
+		{"GET", "/api/shorten?url=https://example.com/a", http.StatusOK, []string{"/a", "s.example/b", "t.example/c"}},
+		{"GET", "/api/shorten?url=https://example.com/a&host=S.example", http.StatusOK, []string{"/a", "s.example/b"}},
+		{"GET", "/api/shorten?url=https://example.com/b", http.StatusNotFound, nil},
+		{"GET", "/api/shorten", http.StatusBadRequest, nil},
+		{"POST", "/api/shorten?url=https://example.com/a", http.StatusMethodNotAllowed, nil},
+	}
+
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", tt.method, tt.url, "")
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("%v %s returned status %v, want %v: %s", tt.method, tt.url, got, want, resp.Body)
+		}
+		if resp.Code != http.StatusOK {
+			continue
+		}
+		var mappings []Mapping
+		if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+			t.Fatalf("error decoding mappings: %v", err)
+		}
+		var paths []string
+		for _, m := range mappings {
+			paths = append(paths, m.Host+m.ShortPath)
+		}
+		if !reflect.DeepEqual(paths, tt.paths) {
+			t.Errorf("%v %s returned %v, want %v", tt.method, tt.url, paths, tt.paths)
+		}
+	}
+}
+
+func TestAdminHandler_Export(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	h := NewAdminHandler(g, "t")
+
+	tests := []struct {
+		method, url string
+		code        int
+		contentType string
+		contains    string
+	}{
+		{"GET", "/api/export", http.StatusOK, "application/json; charset=utf-8", `"path": "/a"`},
+		{"GET", "/api/export?format=csv", http.StatusOK, "text/csv; charset=utf-8", ",/a,false,/pa,301,,manual"},
+		{"GET", "/api/export?format=netlify", http.StatusOK, "text/plain; charset=utf-8", "/a  /pa  301"},
+		{"GET", "/api/export?format=xml", http.StatusBadRequest, "", ""},
+		{"POST", "/api/export", http.StatusMethodNotAllowed, "", ""},
+	}
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", tt.method, tt.url, "")
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("%v %s returned status %v, want %v: %s", tt.method, tt.url, got, want, resp.Body)
+		}
+		if resp.Code != http.StatusOK {
+			continue
+		}
+		if got := resp.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%v %s returned content type %q, want %q", tt.method, tt.url, got, tt.contentType)
+		}
+		if got := resp.Body.String(); !strings.Contains(got, tt.contains) {
+			t.Errorf("%v %s returned %q, want it to contain %q", tt.method, tt.url, got, tt.contains)
+		}
+	}
+}
+
+func TestAdminHandler_Metrics(t *testing.T) {
+	h := NewAdminHandler(NewServer(), "t")
+
+	resp := adminRequest(h, "t", "GET", "/metrics", "")
+	if got, want := resp.Code, http.StatusOK; got != want {
+		t.Errorf("GET /metrics returned status %v, want %v", got, want)
+	}
+	if got, want := resp.Header().Get("Content-Type"), "text/plain; version=0.0.4"; !strings.HasPrefix(got, want) {
+		t.Errorf("GET /metrics returned content type %q, want %q", got, want)
+	}
+	if got := resp.Body.String(); !strings.Contains(got, "gum_not_found_total ") {
+		t.Errorf("GET /metrics returned %q, want gum metrics", got)
+	}
+
+	if resp := adminRequest(h, "", "GET", "/metrics", ""); resp.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated GET /metrics returned status %v, want %v", resp.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminHandler_Clicks(t *testing.T) {
+	g := NewServer()
+	h := NewAdminHandler(g, "t")
+
+	if resp := adminRequest(h, "t", "GET", "/api/clicks", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("GET /api/clicks without tracker returned status %v, want %v", resp.Code, http.StatusNotFound)
+	}
+
+	st := NewMemoryStore()
+	st.AddClicks([]Click{
+		{ShortPath: "/a", Day: "2014-02-21", Count: 1},
+		{ShortPath: "/a", Day: "2014-02-22", Count: 2},
+		{ShortPath: "/b", Day: "2014-02-22", Count: 3},
+	})
+	g.SetClickTracker(NewClickTracker(st))
+
+	tests := []struct {
+		url   string
+		code  int
+		count int // number of clicks returned
+	}{
+		{"/api/clicks", http.StatusOK, 3},
+		{"/api/clicks?path=/a", http.StatusOK, 2},
+		{"/api/clicks?path=/a&from=2014-02-22", http.StatusOK, 1},
+		{"/api/clicks?path=/c", http.StatusOK, 0},
+		{"/api/clicks?from=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		resp := adminRequest(h, "t", "GET", tt.url, "")
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("GET %v returned status %v, want %v", tt.url, got, want)
+		}
+		if resp.Code != http.StatusOK {
+			continue
+		}
+		var clicks []Click
+		if err := json.NewDecoder(resp.Body).Decode(&clicks); err != nil {
+			t.Fatalf("error decoding clicks: %v", err)
+		}
+		if got, want := len(clicks), tt.count; got != want {
+			t.Errorf("GET %v returned %d clicks, want %d", tt.url, got, want)
+		}
+	}
+}
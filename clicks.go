@@ -0,0 +1,283 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dayFormat is the format of the days clicks are counted by.
+const dayFormat = "2006-01-02"
+
+// maxReferrers is the number of distinct referring hosts that clicks on a
+// short URL are counted by each day.  Clicks from further referrers are
+// counted together as otherReferrer, since referrers are supplied by clients.
+const maxReferrers = 100
+
+// otherReferrer is the referrer of clicks from referring hosts beyond the
+// first maxReferrers of the day.  It is not a valid host name.
+const otherReferrer = "(other)"
+
+// memoryClickDays is the number of days that clicks are kept for by a
+// ClickTracker without a store.
+const memoryClickDays = 90
+
+// A Click is the number of times a short URL was followed from a referring
+// host on a single day.
+type Click struct {
+	// Host and ShortPath identify the short URL, as in Mapping.  For path
+	// redirects, ShortPath is the prefix of the RedirectHandler.
+	Host      string `json:"host,omitempty"`
+	ShortPath string `json:"short_path"`
+
+	// Referrer is the host of the referring page, if known.
+	Referrer string `json:"referrer,omitempty"`
+
+	// Day is the UTC date of the clicks, in the form "2006-01-02".
+	Day string `json:"day"`
+
+	// Count is the number of clicks.
+	Count uint64 `json:"count"`
+}
+
+// clickKey identifies the clicks that are counted together.
+type clickKey struct {
+	host, shortPath, referrer, day string
+}
+
+func (c Click) key() clickKey {
+	return clickKey{host: c.Host, shortPath: c.ShortPath, referrer: c.Referrer, day: c.Day}
+}
+
+func (k clickKey) click(count uint64) Click {
+	return Click{Host: k.host, ShortPath: k.shortPath, Referrer: k.referrer, Day: k.day, Count: count}
+}
+
+// A ClickStore persists click counts.
+type ClickStore interface {
+	// LoadClicks returns all clicks in the store.
+	LoadClicks() ([]Click, error)
+
+	// AddClicks adds the counts of clicks to those already stored for the
+	// same short URL, referrer, and day.
+	AddClicks(clicks []Click) error
+}
+
+// ClickFilter selects clicks to report.  Empty fields match all clicks.
+type ClickFilter struct {
+	Host      string
+	ShortPath string
+
+	// From and To are the first and last days to include, in the form
+	// "2006-01-02".
+	From, To string
+}
+
+func (f ClickFilter) match(c Click) bool {
+	return (f.Host == "" || strings.EqualFold(f.Host, c.Host)) &&
+		(f.ShortPath == "" || f.ShortPath == c.ShortPath) &&
+		(f.From == "" || c.Day >= f.From) &&
+		(f.To == "" || c.Day <= f.To)
+}
+
+// ClickTracker counts the clicks on short URLs served by a Server, by short
+// URL, referring host, and day.  Requests with a "DNT: 1" (Do Not Track)
+// header are not counted.  Clicks are counted in memory, and written to the
+// tracker's store, if any, when Flush is called.  Without a store, Flush
+// discards clicks older than 90 days.
+type ClickTracker struct {
+	store ClickStore
+
+	mutex sync.Mutex
+	// clicks which have not yet been flushed to store
+	pending map[clickKey]uint64
+	// referrers counted on referrerDay for each short URL, keyed without
+	// referrer
+	referrers   map[clickKey]map[string]bool
+	referrerDay string
+	// returns the current time
+	now func() time.Time
+}
+
+// NewClickTracker constructs a new ClickTracker which flushes clicks to store.
+// If store is nil, clicks are only counted in memory.
+func NewClickTracker(store ClickStore) *ClickTracker {
+	return &ClickTracker{
+		store:   store,
+		pending: make(map[clickKey]uint64),
+		now:     time.Now,
+	}
+}
+
+// record counts a click on the short URL with the specified host and path
+// for request r.
+func (t *ClickTracker) record(r *http.Request, host, shortPath string) {
+	if r.Header.Get("DNT") == "1" {
+		return
+	}
+	var referrer string
+	if u, err := url.Parse(r.Referer()); err == nil {
+		referrer = strings.ToLower(u.Hostname())
+	}
+
+	k := clickKey{
+		host:      strings.ToLower(host),
+		shortPath: shortPath,
+		referrer:  referrer,
+		day:       t.now().UTC().Format(dayFormat),
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if referrer != "" {
+		k.referrer = t.countReferrer(k)
+	}
+	t.pending[k]++
+}
+
+// countReferrer returns the referrer that clicks for k are counted by, which
+// is otherReferrer once the short URL of k has maxReferrers other referrers
+// for the day.  The caller must hold t.mutex.
+func (t *ClickTracker) countReferrer(k clickKey) string {
+	if t.referrers == nil || t.referrerDay != k.day {
+		t.referrers = make(map[clickKey]map[string]bool)
+		t.referrerDay = k.day
+	}
+
+	short := k
+	short.referrer = ""
+	seen := t.referrers[short]
+	if seen[k.referrer] {
+		return k.referrer
+	}
+	if len(seen) >= maxReferrers {
+		return otherReferrer
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+		t.referrers[short] = seen
+	}
+	seen[k.referrer] = true
+	return k.referrer
+}
+
+// Flush writes the clicks counted since the last flush to the tracker's
+// store.  If the store returns an error, the clicks are kept to be written by
+// the next flush.  If the tracker has no store, clicks older than 90 days are
+// discarded instead.
+func (t *ClickTracker) Flush() error {
+	if t.store == nil {
+		cutoff := t.now().UTC().AddDate(0, 0, -memoryClickDays).Format(dayFormat)
+		t.mutex.Lock()
+		for k := range t.pending {
+			if k.day < cutoff {
+				delete(t.pending, k)
+			}
+		}
+		t.mutex.Unlock()
+		return nil
+	}
+
+	t.mutex.Lock()
+	pending := t.pending
+	t.pending = make(map[clickKey]uint64)
+	t.mutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	clicks := make([]Click, 0, len(pending))
+	for k, n := range pending {
+		clicks = append(clicks, k.click(n))
+	}
+	if err := t.store.AddClicks(clicks); err != nil {
+		t.mutex.Lock()
+		for k, n := range pending {
+			t.pending[k] += n
+		}
+		t.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Clicks returns the clicks matching f, including those not yet flushed,
+// sorted by day, host, short path, and referrer.
+func (t *ClickTracker) Clicks(f ClickFilter) ([]Click, error) {
+	counts := make(map[clickKey]uint64)
+	if t.store != nil {
+		stored, err := t.store.LoadClicks()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range stored {
+			counts[c.key()] += c.Count
+		}
+	}
+	t.mutex.Lock()
+	for k, n := range t.pending {
+		counts[k] += n
+	}
+	t.mutex.Unlock()
+
+	clicks := []Click{}
+	for k, n := range counts {
+		if c := k.click(n); f.match(c) {
+			clicks = append(clicks, c)
+		}
+	}
+	sort.Slice(clicks, func(i, j int) bool {
+		a, b := clicks[i], clicks[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.ShortPath != b.ShortPath {
+			return a.ShortPath < b.ShortPath
+		}
+		return a.Referrer < b.Referrer
+	})
+	return clicks, nil
+}
+
+// SetClickTracker sets the tracker used to count clicks on the short URLs
+// served by s, including mappings and path redirects.  If t is nil, clicks are
+// not counted.
+func (s *Server) SetClickTracker(t *ClickTracker) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clicks = t
+}
+
+// ClickTracker returns the click tracker of s, or nil if it has none.
+func (s *Server) ClickTracker() *ClickTracker {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.clicks
+}
+
+// recordClick counts a click on the short URL with the specified host and
+// path, if s has a click tracker.
+func (s *Server) recordClick(r *http.Request, host, shortPath string) {
+	if t := s.ClickTracker(); t != nil {
+		t.record(r, host, shortPath)
+	}
+}
+
+// recordClick counts a click for a request served by a handler of a Server,
+// as identified by the request context.
+func recordClick(r *http.Request, host, shortPath string) {
+	if s, ok := r.Context().Value(serverKey{}).(*Server); ok {
+		s.recordClick(r, host, shortPath)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClickTracker(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	g.AddMapping(Mapping{Host: "x.example", ShortPath: "/b", Permalink: "/pb"})
+	rh, _ := NewRedirectHandler("w", "https://en.wikipedia.org/wiki/")
+	if err := g.AddHandler(rh); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	st := NewMemoryStore()
+	tracker := NewClickTracker(st)
+	day := time.Date(2014, 2, 21, 23, 0, 0, 0, time.FixedZone("", -2*60*60))
+	tracker.now = func() time.Time { return day }
+	g.SetClickTracker(tracker)
+
+	requests := []struct {
+		url, referrer, dnt string
+	}{
+		{"/a", "", ""},
+		{"/a", "https://Twitter.com/x", ""},
+		{"/a", "https://twitter.com/y", ""},
+		{"/a", "", "1"},
+		{"http://x.example/b", "", ""},
+		{"/w/URL_shortening", "", ""},
+		{"/w/Gum", "", ""},
+		{"/nope", "", ""},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.referrer != "" {
+			req.Header.Set("Referer", tt.referrer)
+		}
+		if tt.dnt != "" {
+			req.Header.Set("DNT", tt.dnt)
+		}
+		g.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// clicks are counted by UTC day
+	want := []Click{
+		{ShortPath: "/a", Day: "2014-02-22", Count: 1},
+		{ShortPath: "/a", Referrer: "twitter.com", Day: "2014-02-22", Count: 2},
+		{ShortPath: "/w", Day: "2014-02-22", Count: 2},
+		{Host: "x.example", ShortPath: "/b", Day: "2014-02-22", Count: 1},
+	}
+	if got, err := tracker.Clicks(ClickFilter{}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Clicks returned %v, %v, want %v", got, err, want)
+	}
+
+	// flushed clicks are added to those in the store
+	st.AddClicks([]Click{{ShortPath: "/a", Day: "2014-02-21", Count: 5}})
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if got, want := len(tracker.pending), 0; got != want {
+		t.Errorf("Flush left %d pending clicks, want %d", got, want)
+	}
+	want = []Click{
+		{ShortPath: "/a", Day: "2014-02-21", Count: 5},
+		{ShortPath: "/a", Day: "2014-02-22", Count: 1},
+		{ShortPath: "/a", Referrer: "twitter.com", Day: "2014-02-22", Count: 2},
+	}
+	if got, err := tracker.Clicks(ClickFilter{ShortPath: "/a"}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Clicks returned %v, %v, want %v", got, err, want)
+	}
+	want = []Click{{ShortPath: "/a", Day: "2014-02-21", Count: 5}}
+	if got, err := tracker.Clicks(ClickFilter{ShortPath: "/a", To: "2014-02-21"}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Clicks returned %v, %v, want %v", got, err, want)
+	}
+}
+
+// failingClickStore is a ClickStore which fails to add clicks.
+type failingClickStore struct{}
+
+func (failingClickStore) LoadClicks() ([]Click, error) { return nil, nil }
+func (failingClickStore) AddClicks([]Click) error      { return errors.New("failed") }
+
+func TestClickTracker_FlushError(t *testing.T) {
+	tracker := NewClickTracker(failingClickStore{})
+	req := httptest.NewRequest("GET", "/a", nil)
+	tracker.record(req, "", "/a")
+	if err := tracker.Flush(); err == nil {
+		t.Errorf("Flush did not return expected error")
+	}
+
+	// clicks are kept for the next flush
+	tracker.record(req, "", "/a")
+	if got, want := len(tracker.pending), 1; got != want {
+		t.Fatalf("tracker has %d pending clicks, want %d", got, want)
+	}
+	for _, n := range tracker.pending {
+		if n != 2 {
+			t.Errorf("tracker has %d pending clicks for /a, want 2", n)
+		}
+	}
+}
+
+func TestClickTracker_Referrers(t *testing.T) {
+	tracker := NewClickTracker(nil)
+	day := time.Date(2014, 2, 21, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return day }
+
+	click := func(referrer string) {
+		req := httptest.NewRequest("GET", "/a", nil)
+		req.Header.Set("Referer", "https://"+referrer+"/")
+		tracker.record(req, "", "/a")
+	}
+	for i := 0; i < maxReferrers+2; i++ {
+		click(fmt.Sprintf("r%d.example", i))
+	}
+	click("r0.example")
+
+	// referrers beyond the limit are counted together
+	clicks, err := tracker.Clicks(ClickFilter{})
+	if err != nil {
+		t.Fatalf("Clicks returned error: %v", err)
+	}
+	if got, want := len(clicks), maxReferrers+1; got != want {
+		t.Fatalf("Clicks returned %d clicks, want %d", got, want)
+	}
+	counts := make(map[string]uint64)
+	for _, c := range clicks {
+		counts[c.Referrer] = c.Count
+	}
+	if got, want := counts[otherReferrer], uint64(2); got != want {
+		t.Errorf("counted %d clicks from other referrers, want %d", got, want)
+	}
+	if got, want := counts["r0.example"], uint64(2); got != want {
+		t.Errorf("counted %d clicks from r0.example, want %d", got, want)
+	}
+
+	// the limit applies to each day
+	day = day.AddDate(0, 0, 1)
+	click(fmt.Sprintf("r%d.example", maxReferrers+1))
+	f := ClickFilter{From: "2014-02-22"}
+	want := []Click{{ShortPath: "/a", Referrer: fmt.Sprintf("r%d.example", maxReferrers+1), Day: "2014-02-22", Count: 1}}
+	if got, err := tracker.Clicks(f); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Clicks returned %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestClickTracker_NoStore(t *testing.T) {
+	tracker := NewClickTracker(nil)
+	day := time.Date(2014, 2, 21, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return day }
+	tracker.record(httptest.NewRequest("GET", "/a", nil), "", "/a")
+
+	// clicks are kept in memory until they expire
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if got, want := len(tracker.pending), 1; got != want {
+		t.Errorf("tracker has %d pending clicks, want %d", got, want)
+	}
+	day = day.AddDate(0, 0, memoryClickDays+1)
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if got, want := len(tracker.pending), 0; got != want {
+		t.Errorf("tracker has %d pending clicks, want %d", got, want)
+	}
+}
+
+func TestBoltStore_Clicks(t *testing.T) {
+	st, err := OpenBoltStore(filepath.Join(t.TempDir(), "gum.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore returned error: %v", err)
+	}
+	defer st.Close()
+
+	a := Click{ShortPath: "/a", Day: "2014-02-21", Count: 1}
+	b := Click{Host: "x.example", ShortPath: "/b", Referrer: "twitter.com", Day: "2014-02-21", Count: 2}
+	for i := 0; i < 2; i++ {
+		if err := st.AddClicks([]Click{a, b}); err != nil {
+			t.Fatalf("AddClicks returned error: %v", err)
+		}
+	}
+	a.Count, b.Count = 2, 4
+	if got, err := st.LoadClicks(); err != nil || !reflect.DeepEqual(got, []Click{a, b}) {
+		t.Errorf("LoadClicks returned %v, %v, want %v", got, err, []Click{a, b})
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+
+	"willnorris.com/go/gum"
+)
+
+// clickGroups are the ways clicks can be grouped in a report, keyed by name.
+var clickGroups = map[string]func(c gum.Click) string{
+	"path": func(c gum.Click) string { return c.Host + c.ShortPath },
+	"referrer": func(c gum.Click) string {
+		if c.Referrer == "" {
+			return "(none)"
+		}
+		return c.Referrer
+	},
+	"day": func(c gum.Click) string { return c.Day },
+}
+
+// runClicks implements the "gum clicks" command, which reports the clicks
+// counted by a running gum server.
+func runClicks(args []string) error {
+	fs := flag.NewFlagSet("clicks", flag.ExitOnError)
+	addr := fs.String("admin_addr", "localhost:4595", "TCP address of the gum admin API")
+	token := fs.String("admin_token", "", "bearer token for admin API requests (defaults to $GUM_ADMIN_TOKEN)")
+	host := fs.String("host", "", "only report clicks on short URLs for this host")
+	path := fs.String("path", "", "only report clicks on this short path")
+	from := fs.String("from", "", "first day to report, of the form YYYY-MM-DD")
+	to := fs.String("to", "", "last day to report, of the form YYYY-MM-DD")
+	by := fs.String("by", "path", "group clicks by 'path', 'referrer', or 'day'")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage:
+  gum clicks [-admin_addr=<addr>] [-path=<path>] [-from=<day>] [-to=<day>] [-by=<group>]
+
+Clicks prints the number of clicks on short URLs counted by a running gum
+server, grouped by short URL, referring host, or day, with the most clicked
+first.  Gum must be running with the admin API and click tracking enabled.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	group, ok := clickGroups[*by]
+	if !ok {
+		return fmt.Errorf("unknown click grouping %q", *by)
+	}
+	if *token == "" {
+		*token = os.Getenv("GUM_ADMIN_TOKEN")
+	}
+
+	query := url.Values{}
+	for name, v := range map[string]string{"host": *host, "path": *path, "from": *from, "to": *to} {
+		if v != "" {
+			query.Set(name, v)
+		}
+	}
+	var clicks []gum.Click
+	if err := adminCall(*addr, *token, http.MethodGet, "/api/clicks?"+query.Encode(), nil, &clicks); err != nil {
+		return err
+	}
+	return writeClickReport(os.Stdout, clicks, group)
+}
+
+// writeClickReport writes the total clicks of each group of clicks to w, in
+// decreasing order of clicks.
+func writeClickReport(w io.Writer, clicks []gum.Click, group func(gum.Click) string) error {
+	totals := make(map[string]uint64)
+	var total uint64
+	for _, c := range clicks {
+		totals[group(c)] += c.Count
+		total += c.Count
+	}
+	keys := make([]string, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	// right align counts to the width of the total, which is the largest
+	width := len(fmt.Sprint(total))
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "%*d  %s\n", width, totals[k], k); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%*d  total\n", width, total)
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"willnorris.com/go/gum"
+)
+
+func TestWriteClickReport(t *testing.T) {
+	clicks := []gum.Click{
+		{ShortPath: "/a", Day: "2014-02-21", Count: 1},
+		{ShortPath: "/a", Referrer: "twitter.com", Day: "2014-02-22", Count: 2},
+		{Host: "x.example", ShortPath: "/b", Day: "2014-02-22", Count: 12},
+		{ShortPath: "/c", Referrer: "twitter.com", Day: "2014-02-22", Count: 3},
+	}
+
+	tests := []struct {
+		by, want string
+	}{
+		{"path", "" +
+			"12  x.example/b\n" +
+			" 3  /a\n" +
+			" 3  /c\n" +
+			"18  total\n"},
+		{"referrer", "" +
+			"13  (none)\n" +
+			" 5  twitter.com\n" +
+			"18  total\n"},
+		{"day", "" +
+			"17  2014-02-22\n" +
+			" 1  2014-02-21\n" +
+			"18  total\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeClickReport(&buf, clicks, clickGroups[tt.by]); err != nil {
+			t.Fatalf("writeClickReport returned error: %v", err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("writeClickReport by %s returned:\n%s\nwant:\n%s", tt.by, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,684 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"willnorris.com/go/gum"
+)
+
+// config describes the listeners and handlers of a gum server.  A config can
+// be loaded from a JSON file of the form:
+//
+//     {
+//       "listen": ["localhost:4594"],
+//       "static": [
+//         {"dir": "/var/www/example.com/public", "whistle": {"types": {"t": "/notes/"}}, "tombstones": true}
+//       ],
+//       "redirects": [
+//         {"prefix": "w", "destination": "https://en.wikipedia.org/wiki/"},
+//         {"host": "x.example", "prefix": "c", "destination": "/code/", "status": 302}
+//       ],
+//       "rules": [
+//         {"pattern": "/i/{id:[0-9]+}", "destination": "https://github.com/org/repo/issues/{id}"}
+//       ],
+//       "mappings": [
+//         {"short_path": "/gum", "permalink": "https://github.com/willnorris/gum"}
+//       ],
+//       "admin": {"listen": "localhost:4595", "token": "secret"},
+//       "store": "/var/lib/gum/gum.db",
+//       "clicks": true,
+//       "generator": {"alphabet": "newbase60", "length": 4, "reserved": ["api"]},
+//       "tombstones": {"retention": "2160h", "page": "/var/www/gone.html"},
+//       "not_found": {"redirect": "https://example.com/search?q={path}"},
+//       "access_log": {"path": "/var/log/gum/access.log", "format": "json", "trusted_proxies": ["127.0.0.1"]},
+//       "health": {"listen": "localhost:4596", "liveness_path": "/healthz", "readiness_path": "/readyz"}
+//     }
+type config struct {
+	// Listen is the list of TCP addresses to listen on.
+	Listen []string
+
+	// Static is the list of static site directories to setup redirects for.
+	Static []staticSite
+
+	// Redirects is the list of redirect handlers.
+	Redirects []redirect
+
+	// Rules is the list of pattern-based redirect rules, in order of
+	// evaluation.
+	Rules []rule
+
+	// Mappings is the list of one-off static mappings.
+	Mappings []mapping
+
+	// Admin configures the admin API.
+	Admin admin
+
+	// Store is the path of the database file used to persist mappings
+	// created with the admin API.  If empty, such mappings are lost when
+	// gum exits.
+	Store string
+
+	// Generator configures how short paths are generated for new mappings.
+	Generator generator
+
+	// Clicks specifies whether clicks on short URLs are counted.  Clicks
+	// are written to Store, if specified.
+	Clicks bool
+
+	// Tombstones configures how retired short URLs are served.
+	Tombstones tombstones
+
+	// NotFound configures how requests which nothing matches are served.
+	NotFound notFound
+
+	// AccessLog configures the log of served requests.
+	AccessLog accessLog
+
+	// Health configures the liveness and readiness checks.  If nil, they
+	// are not served.
+	Health *health
+}
+
+type admin struct {
+	// Listen is the TCP address to serve the admin API on.  If empty, the
+	// admin API is disabled.
+	Listen string `json:"listen"`
+
+	// Token is the bearer token required for admin API requests.  If
+	// empty, the GUM_ADMIN_TOKEN environment variable is used.
+	Token string `json:"token"`
+}
+
+func (a admin) validate() error {
+	if a.Listen != "" && a.Token == "" {
+		return errors.New("admin token must be specified to enable the admin API")
+	}
+	return nil
+}
+
+type generator struct {
+	// Alphabet is the name of the alphabet short codes are made of, one
+	// of "base62", "newbase60", or "crockford32".
+	Alphabet string   `json:"alphabet"`
+	Length   int      `json:"length"`
+	Prefix   string   `json:"prefix"`
+	Reserved []string `json:"reserved"`
+}
+
+// alphabets maps the names of short code alphabets to their characters.
+var alphabets = map[string]string{
+	"":            "",
+	"base62":      gum.Base62,
+	"newbase60":   gum.NewBase60,
+	"crockford32": gum.Crockford32,
+}
+
+// generator returns the gum.Generator described by g.
+func (g generator) generator() gum.Generator {
+	return gum.Generator{
+		Alphabet: alphabets[strings.ToLower(g.Alphabet)],
+		Length:   g.Length,
+		Prefix:   g.Prefix,
+		Reserved: g.Reserved,
+	}
+}
+
+func (g generator) validate() error {
+	if _, ok := alphabets[strings.ToLower(g.Alphabet)]; !ok {
+		return fmt.Errorf("unknown generator alphabet %q", g.Alphabet)
+	}
+	gen := g.generator()
+	return gen.Validate()
+}
+
+type tombstones struct {
+	// Retention is how long tombstones are kept, as a duration string
+	// such as "720h".  If empty, tombstones are kept forever.
+	Retention string `json:"retention"`
+
+	// Page is the path of an HTML file served for retired short URLs.
+	Page string `json:"page"`
+}
+
+// retention returns the parsed retention period of t.
+func (t tombstones) retention() (time.Duration, error) {
+	if t.Retention == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(t.Retention)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tombstone retention: %v", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("tombstone retention %q must not be negative", t.Retention)
+	}
+	return d, nil
+}
+
+func (t tombstones) validate() error {
+	if _, err := t.retention(); err != nil {
+		return err
+	}
+	if t.Page != "" {
+		if _, err := os.Stat(t.Page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type notFound struct {
+	// Template is the path of an HTML template served with a 404 status.
+	Template string `json:"template"`
+
+	// Redirect is the URL to redirect to, in which "{path}" is replaced
+	// with the request path.  Status is the redirect status, 302 (Found)
+	// by default.
+	Redirect string `json:"redirect"`
+	Status   int    `json:"status"`
+
+	// Proxy is the base URL of an upstream server to proxy requests to.
+	Proxy string `json:"proxy"`
+}
+
+// handler returns the not found handler described by n, or nil if n does not
+// specify one.
+func (n notFound) handler() (http.Handler, error) {
+	switch {
+	case n.Template != "":
+		t, err := template.ParseFiles(n.Template)
+		if err != nil {
+			return nil, err
+		}
+		return gum.NotFoundTemplate(t), nil
+	case n.Redirect != "":
+		status := n.Status
+		if status == 0 {
+			status = http.StatusFound
+		}
+		return gum.NotFoundRedirect(n.Redirect, status), nil
+	case n.Proxy != "":
+		u, err := url.Parse(n.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("proxy %q must be an absolute URL", n.Proxy)
+		}
+		return gum.NotFoundProxy(u), nil
+	}
+	return nil, nil
+}
+
+func (n notFound) validate() error {
+	var set int
+	for _, v := range []string{n.Template, n.Redirect, n.Proxy} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("only one of not found template, redirect, and proxy may be specified")
+	}
+	if n.Redirect != "" {
+		if _, err := url.Parse(n.Redirect); err != nil {
+			return fmt.Errorf("not found redirect %q is not a valid URL: %v", n.Redirect, err)
+		}
+	}
+	if err := validateStatus(n.Status); err != nil {
+		return err
+	}
+	_, err := n.handler()
+	return err
+}
+
+type accessLog struct {
+	// Path is the file requests are logged to, or "-" for standard
+	// output.  If empty, requests are not logged.
+	Path string `json:"path"`
+
+	// Format is the format of log entries, "combined" (the default) or
+	// "json".
+	Format string `json:"format"`
+
+	// TrustedProxies are the IP addresses or CIDR networks of proxies
+	// whose X-Forwarded-For headers identify the client.
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// format returns the log format of a, defaulting to the combined format.
+func (a accessLog) format() string {
+	if a.Format == "" {
+		return gum.LogCombined
+	}
+	return a.Format
+}
+
+// open returns the gum.AccessLog described by a, or nil if a does not specify
+// a path.
+func (a accessLog) open() (*gum.AccessLog, error) {
+	if a.Path == "" {
+		return nil, nil
+	}
+	proxies, err := gum.ParseNetworks(a.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var l *gum.AccessLog
+	if a.Path == "-" {
+		l, err = gum.NewAccessLog(os.Stdout, a.format())
+	} else {
+		l, err = gum.OpenAccessLog(a.Path, a.format())
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.TrustedProxies = proxies
+	return l, nil
+}
+
+func (a accessLog) validate() error {
+	if f := a.format(); f != gum.LogCombined && f != gum.LogJSON {
+		return fmt.Errorf("unknown access log format %q", a.Format)
+	}
+	_, err := gum.ParseNetworks(a.TrustedProxies)
+	return err
+}
+
+type health struct {
+	// Listen is the TCP address to serve health checks on.  If empty,
+	// they are served on the Listen addresses of the config.
+	Listen string `json:"listen"`
+
+	// LivenessPath and ReadinessPath are the paths of the checks,
+	// "/healthz" and "/readyz" by default.
+	LivenessPath  string `json:"liveness_path"`
+	ReadinessPath string `json:"readiness_path"`
+}
+
+// handler returns the gum.HealthHandler described by h, which serves other
+// requests with next.
+func (h health) handler(g *gum.Server, next http.Handler) *gum.HealthHandler {
+	hh := gum.NewHealthHandler(g, next)
+	hh.LivenessPath, hh.ReadinessPath = h.LivenessPath, h.ReadinessPath
+	return hh
+}
+
+func (h health) validate() error {
+	for _, p := range []string{h.LivenessPath, h.ReadinessPath} {
+		if p != "" && !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("health check path %q should begin with a slash", p)
+		}
+	}
+	if h.LivenessPath != "" && h.LivenessPath == h.ReadinessPath {
+		return errors.New("liveness and readiness paths must be different")
+	}
+	return nil
+}
+
+type staticSite struct {
+	Dir        string          `json:"dir"`
+	MatchHost  bool            `json:"match_host"`
+	Whistle    *whistle        `json:"whistle"`
+	Query      gum.QueryPolicy `json:"query"`
+	Tombstones bool            `json:"tombstones"`
+
+	line int // line number in config file
+}
+
+func (d staticSite) validate() error {
+	if d.Dir == "" {
+		return errors.New("static dir must not be empty")
+	}
+	if stat, err := os.Stat(d.Dir); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("static dir %q is not a directory", d.Dir)
+	}
+	if d.Whistle != nil {
+		if err := d.Whistle.whistle().Validate(); err != nil {
+			return err
+		}
+	}
+	return d.Query.Validate()
+}
+
+type whistle struct {
+	// Types maps content type prefixes to permalink path prefixes.
+	Types map[string]string `json:"types"`
+	Host  string            `json:"host"`
+}
+
+// whistle returns the gum.Whistle described by w.
+func (w whistle) whistle() *gum.Whistle {
+	return &gum.Whistle{Types: w.Types, Host: w.Host}
+}
+
+// parseWhistleTypes parses a comma separated list of whistle types of the
+// form "type=prefix".
+func parseWhistleTypes(value string) (map[string]string, error) {
+	types := make(map[string]string)
+	for _, t := range strings.Split(value, ",") {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("whistle type %q should be of the form 'type=prefix'", t)
+		}
+		types[parts[0]] = parts[1]
+	}
+	return types, nil
+}
+
+type redirect struct {
+	Host        string          `json:"host"`
+	Prefix      string          `json:"prefix"`
+	Destination string          `json:"destination"`
+	Status      int             `json:"status"`
+	Query       gum.QueryPolicy `json:"query"`
+
+	line int // line number in config file
+}
+
+func (r redirect) validate() error {
+	if _, err := url.Parse(r.Destination); err != nil {
+		return fmt.Errorf("Destination %q is not a valid URL: %v", r.Destination, err)
+	}
+	if err := validateStatus(r.Status); err != nil {
+		return err
+	}
+	return r.Query.Validate()
+}
+
+// validateStatus returns an error if status is not zero or a redirect status.
+func validateStatus(status int) error {
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("Status %d is not a valid redirect status", status)
+	}
+	return nil
+}
+
+type rule struct {
+	Host        string          `json:"host"`
+	Pattern     string          `json:"pattern"`
+	Destination string          `json:"destination"`
+	Status      int             `json:"status"`
+	Query       gum.QueryPolicy `json:"query"`
+
+	line int // line number in config file
+}
+
+// rule returns the gum.Rule described by r.
+func (r rule) rule() (*gum.Rule, error) {
+	gr, err := gum.NewRule(r.Pattern, r.Destination)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != 0 {
+		gr.Status = r.Status
+	}
+	gr.Query = r.Query
+	return gr, nil
+}
+
+func (r rule) validate() error {
+	if strings.Contains(r.Host, "/") {
+		return fmt.Errorf("host %q should not contain a slash", r.Host)
+	}
+	if _, err := r.rule(); err != nil {
+		return err
+	}
+	if err := validateStatus(r.Status); err != nil {
+		return err
+	}
+	return r.Query.Validate()
+}
+
+type mapping struct {
+	Host      string          `json:"host"`
+	ShortPath string          `json:"short_path"`
+	Permalink string          `json:"permalink"`
+	Status    int             `json:"status"`
+	Query     gum.QueryPolicy `json:"query"`
+
+	line int // line number in config file
+}
+
+func (m mapping) validate() error {
+	if !strings.HasPrefix(m.ShortPath, "/") || len(m.ShortPath) < 2 {
+		return fmt.Errorf("short path %q should be a path with a leading slash", m.ShortPath)
+	}
+	if m.Status == http.StatusGone {
+		// retired short paths don't need a permalink
+	} else if err := validateStatus(m.Status); err != nil {
+		return err
+	} else if m.Permalink == "" {
+		return errors.New("permalink must not be empty")
+	}
+	if _, err := url.Parse(m.Permalink); err != nil {
+		return fmt.Errorf("permalink %q is not a valid URL: %v", m.Permalink, err)
+	}
+	return m.Query.Validate()
+}
+
+// readConfig reads and validates the config file at path.
+func readConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return c, nil
+}
+
+// validator is implemented by config values that can validate themselves.
+type validator interface {
+	validate() error
+}
+
+// configError is an error found at a particular line of a config file.
+type configError struct {
+	line int
+	err  error
+}
+
+func (e *configError) Error() string { return fmt.Sprintf("%d: %v", e.line, e.err) }
+func (e *configError) Unwrap() error { return e.err }
+
+// parseConfig parses and validates the JSON config in data.  Returned errors
+// identify the line of data they occurred on.
+func parseConfig(data []byte) (*config, error) {
+	c := new(config)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	// wrapErr returns err as a configError, using the offset of a JSON
+	// decoding error if available, or else the provided line.  Type errors
+	// report offsets relative to the start of the value being decoded,
+	// which is specified by base.
+	wrapErr := func(err error, line int, base int64) error {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			line = lineAt(data, syntaxErr.Offset)
+		} else if errors.As(err, &typeErr) {
+			line = lineAt(data, base+typeErr.Offset)
+		} else if err == io.ErrUnexpectedEOF {
+			line = lineAt(data, int64(len(data)))
+		}
+		return &configError{line: line, err: err}
+	}
+
+	// decodeList decodes each element of a JSON array into a value
+	// returned by next, passing the line each element starts on.
+	decodeList := func(next func(line int) validator) error {
+		if err := expectDelim(dec, '['); err != nil {
+			return wrapErr(err, lineAt(data, dec.InputOffset()), 0)
+		}
+		for dec.More() {
+			start := dec.InputOffset()
+			line := lineAt(data, start)
+			v := next(line)
+			if err := dec.Decode(v); err != nil {
+				return wrapErr(err, line, start)
+			}
+			if err := v.validate(); err != nil {
+				return &configError{line: line, err: err}
+			}
+		}
+		_, err := dec.Token()
+		return err
+	}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, wrapErr(err, 1, 0)
+	}
+	for dec.More() {
+		line := lineAt(data, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, wrapErr(err, line, 0)
+		}
+
+		switch key := tok.(string); key {
+		case "listen":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Listen); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+		case "static":
+			err = decodeList(func(line int) validator {
+				c.Static = append(c.Static, staticSite{line: line})
+				return &c.Static[len(c.Static)-1]
+			})
+		case "redirects":
+			err = decodeList(func(line int) validator {
+				c.Redirects = append(c.Redirects, redirect{line: line})
+				return &c.Redirects[len(c.Redirects)-1]
+			})
+		case "admin":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Admin); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+		case "store":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Store); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+		case "clicks":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Clicks); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+		case "generator":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Generator); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+			if err := c.Generator.validate(); err != nil {
+				return nil, &configError{line: line, err: err}
+			}
+		case "tombstones":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Tombstones); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+			if err := c.Tombstones.validate(); err != nil {
+				return nil, &configError{line: line, err: err}
+			}
+		case "not_found":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.NotFound); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+			if err := c.NotFound.validate(); err != nil {
+				return nil, &configError{line: line, err: err}
+			}
+		case "access_log":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.AccessLog); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+			if err := c.AccessLog.validate(); err != nil {
+				return nil, &configError{line: line, err: err}
+			}
+		case "health":
+			start := dec.InputOffset()
+			if err := dec.Decode(&c.Health); err != nil {
+				return nil, wrapErr(err, line, start)
+			}
+			if c.Health != nil {
+				if err := c.Health.validate(); err != nil {
+					return nil, &configError{line: line, err: err}
+				}
+			}
+		case "rules":
+			err = decodeList(func(line int) validator {
+				c.Rules = append(c.Rules, rule{line: line})
+				return &c.Rules[len(c.Rules)-1]
+			})
+		case "mappings":
+			err = decodeList(func(line int) validator {
+				c.Mappings = append(c.Mappings, mapping{line: line})
+				return &c.Mappings[len(c.Mappings)-1]
+			})
+		default:
+			err = &configError{line: line, err: fmt.Errorf("unknown config key %q", key)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, wrapErr(err, lineAt(data, dec.InputOffset()), 0)
+	}
+
+	return c, nil
+}
+
+// expectDelim reads the next token from dec, returning an error if it is not
+// the delimiter d.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("expected %q, found %v", d, tok)
+	}
+	return nil
+}
+
+// lineAt returns the line number of the first non-whitespace character at or
+// after offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,170 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"willnorris.com/go/gum"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	input := `{
+  "listen": ["localhost:4594", ":8080"],
+  "static": [
+    {"dir": "` + dir + `", "match_host": true},
+    {"dir": "` + dir + `", "whistle": {"types": {"t": "/notes/"}}, "tombstones": true}
+  ],
+  "redirects": [
+    {"prefix": "w", "destination": "https://en.wikipedia.org/wiki/"},
+    {"host": "x.example", "prefix": "c", "destination": "/code/", "status": 302}
+  ],
+  "mappings": [
+    {"short_path": "/gum", "permalink": "https://github.com/willnorris/gum", "query": "merge utm_source=short"},
+    {"short_path": "/old", "status": 410}
+  ],
+  "rules": [
+    {"pattern": "/i/{id:[0-9]+}", "destination": "https://github.com/org/repo/issues/{id}"},
+    {"host": "x.example", "pattern": "^/p([0-9]+)$", "destination": "/posts/{1}", "status": 302}
+  ],
+  "admin": {"listen": "localhost:4595", "token": "secret"},
+  "store": "/var/lib/gum/gum.db",
+  "clicks": true,
+  "generator": {"alphabet": "crockford32", "length": 6, "reserved": ["api"]},
+  "tombstones": {"retention": "720h"},
+  "not_found": {"redirect": "https://example.com/search?q={path}"},
+  "access_log": {"path": "/var/log/gum/access.log", "format": "json", "trusted_proxies": ["127.0.0.1", "10.0.0.0/8"]},
+  "health": {"listen": "localhost:4596", "readiness_path": "/ready"}
+}`
+
+	got, err := parseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("parseConfig returned error: %v", err)
+	}
+
+	want := &config{
+		Listen: []string{"localhost:4594", ":8080"},
+		Static: []staticSite{
+			{Dir: dir, MatchHost: true, line: 4},
+			{Dir: dir, Whistle: &whistle{Types: map[string]string{"t": "/notes/"}}, Tombstones: true, line: 5},
+		},
+		Redirects: []redirect{
+			{Prefix: "w", Destination: "https://en.wikipedia.org/wiki/", line: 8},
+			{Host: "x.example", Prefix: "c", Destination: "/code/", Status: 302, line: 9},
+		},
+		Mappings: []mapping{
+			{ShortPath: "/gum", Permalink: "https://github.com/willnorris/gum", Query: "merge utm_source=short", line: 12},
+			{ShortPath: "/old", Status: 410, line: 13},
+		},
+		Rules: []rule{
+			{Pattern: "/i/{id:[0-9]+}", Destination: "https://github.com/org/repo/issues/{id}", line: 16},
+			{Host: "x.example", Pattern: "^/p([0-9]+)$", Destination: "/posts/{1}", Status: 302, line: 17},
+		},
+		Admin:      admin{Listen: "localhost:4595", Token: "secret"},
+		Store:      "/var/lib/gum/gum.db",
+		Clicks:     true,
+		Generator:  generator{Alphabet: "crockford32", Length: 6, Reserved: []string{"api"}},
+		Tombstones: tombstones{Retention: "720h"},
+		NotFound:   notFound{Redirect: "https://example.com/search?q={path}"},
+		AccessLog:  accessLog{Path: "/var/log/gum/access.log", Format: "json", TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"}},
+		Health:     &health{Listen: "localhost:4596", ReadinessPath: "/ready"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseConfig returned %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitStatus(t *testing.T) {
+	tests := []struct {
+		in     string
+		status int
+		dest   string
+	}{
+		{"http://example/", 0, "http://example/"},
+		{"302:http://example/", 302, "http://example/"},
+		{"/code/", 0, "/code/"},
+		{"307:/code/", 307, "/code/"},
+		{"abc:/code/", 0, "abc:/code/"},
+		{"302:", 0, "302:"},
+	}
+
+	for _, tt := range tests {
+		status, dest := splitStatus(tt.in)
+		if status != tt.status || dest != tt.dest {
+			t.Errorf("splitStatus(%q) returned %v, %q, want %v, %q", tt.in, status, dest, tt.status, tt.dest)
+		}
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		input string
+		line  int
+	}{
+		{`[]`, 1},
+		{"{\n  \"bogus\": []\n}", 2},
+		{"{\n  \"listen\": [\"a\"],\n  \"redirects\": [\n    {\"prefix\": \"x\", \"destination\": \":\"}\n  ]\n}", 4},
+		{"{\n  \"redirects\": [\n    {\"prefix\": \"x\"},\n    {\"prefix\": \"x\", \"status\": 200}\n  ]\n}", 4},
+		{"{\n  \"redirects\": [\n    {\"prefix\": \"x\",\n     \"status\": \"302\"}\n  ]\n}", 4},
+		{"{\n  \"mappings\": [\n    {\"short_path\": \"/x\", \"permalink\": \"/y\", \"bogus\": 1}\n  ]\n}", 3},
+		{"{\n  \"mappings\": [\n    {\"short_path\": \"x\", \"permalink\": \"/y\"}\n  ]\n}", 3},
+		{"{\n  \"mappings\": [\n    {\"short_path\": \"/x\", \"permalink\": \"/y\", \"query\": \"bogus\"}\n  ]\n}", 3},
+		{"{\n  \"mappings\": [\n    {\"short_path\": \"/x\", \"permalink\": \"/y\", \"status\": 404}\n  ]\n}", 3},
+		{"{\n  \"mappings\": [\n    {\"short_path\": \"/x\", \"status\": 302}\n  ]\n}", 3},
+		{"{\n  \"static\": [\n    {\"dir\": \"/does/not/exist\"}\n  ]\n}", 3},
+		{"{\n  \"static\": [\n    {\"dir\": \".\"},\n    {\"dir\": \".\", \"whistle\": {\"types\": {}}}\n  ]\n}", 4},
+		{"{\n  \"listen\": [\"a\"]\n  \"static\": []\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"admin\": {\"listen\": 1}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"store\": true\n}", 3},
+		{"{\n  \"rules\": [\n    {\"pattern\": \"/i/{id}\", \"destination\": \"/{x}\"}\n  ]\n}", 3},
+		{"{\n  \"rules\": [\n    {\"pattern\": \"/i/{id}\", \"destination\": \"/{id}\", \"status\": 200}\n  ]\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"generator\": {\"alphabet\": \"base2\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"generator\": {\"prefix\": \"t\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"tombstones\": {\"retention\": \"30d\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"tombstones\": {\"retention\": \"-1h\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"tombstones\": {\"page\": \"/does/not/exist\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"not_found\": {\"redirect\": \"/\", \"proxy\": \"http://a/\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"not_found\": {\"redirect\": \"/\", \"status\": 404}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"not_found\": {\"proxy\": \"/upstream\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"not_found\": {\"template\": \"/does/not/exist\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"access_log\": {\"path\": \"-\", \"format\": \"common\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"access_log\": {\"path\": \"-\", \"trusted_proxies\": [\"nginx\"]}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"health\": {\"readiness_path\": \"readyz\"}\n}", 3},
+		{"{\n  \"listen\": [\"a\"],\n  \"health\": {\"liveness_path\": \"/z\", \"readiness_path\": \"/z\"}\n}", 3},
+	}
+
+	for _, tt := range tests {
+		_, err := parseConfig([]byte(tt.input))
+		var cerr *configError
+		if !errors.As(err, &cerr) {
+			t.Errorf("parseConfig(%q) returned error %v, want configError", tt.input, err)
+			continue
+		}
+		if got, want := cerr.line, tt.line; got != want {
+			t.Errorf("parseConfig(%q) returned error on line %d, want %d: %v", tt.input, got, want, err)
+		}
+	}
+}
+
+func TestNewHandlers_Redirects(t *testing.T) {
+	c := &config{Redirects: []redirect{{Host: "A.example", Prefix: "x", Destination: "/a/"}}}
+	handlers, err := newHandlers(c)
+	if err != nil {
+		t.Fatalf("newHandlers returned error: %v", err)
+	}
+	if h, ok := handlers[0].(*gum.RedirectHandler); !ok || h.Host != "a.example" {
+		t.Errorf("newHandlers returned %#v, want redirect handler for a.example", handlers[0])
+	}
+
+	c = &config{Redirects: []redirect{{Prefix: "/x", Destination: "/a/"}}}
+	if _, err := newHandlers(c); err == nil {
+		t.Errorf("newHandlers with invalid prefix did not return expected error")
+	}
+}
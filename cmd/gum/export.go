@@ -0,0 +1,121 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"willnorris.com/go/gum"
+)
+
+// exportFlags are the flags of the gum server which the "gum export" command
+// accepts, to load the redirects of a server which is not running.
+var exportFlags = []string{
+	"config", "static_dir", "static_hosts", "static_whistle", "static_tombstones",
+	"redirect", "store", "tombstone_retention",
+}
+
+// runExport implements the "gum export" command, which writes the redirects
+// served by gum in one of gum.ExportFormats.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := fs.String("admin_addr", "localhost:4595", "TCP address of the gum admin API")
+	token := fs.String("admin_token", "", "bearer token for admin API requests (defaults to $GUM_ADMIN_TOKEN)")
+	format := fs.String("format", gum.ExportJSON, "export format: "+strings.Join(gum.ExportFormats, ", "))
+	output := fs.String("o", "", "file to write the export to, instead of standard output")
+	for _, name := range exportFlags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage:
+  gum export [-admin_addr=<addr>] [-format=<format>] [-o=<file>]
+  gum export -config=<file> | -static_dir=<dir> [-format=<format>] [-o=<file>]
+
+Export writes the mappings and path redirects served by gum, in order of
+precedence.  The json and csv formats list each redirect and where it came
+from, while the nginx, apache, netlify, and caddy formats are web server
+configurations which serve the same redirects.
+
+By default, the redirects are read from the admin API of a running gum server.
+If a config file, static directory, or redirect is specified, gum instead loads
+them itself, as it would when starting, so they can be exported while gum is
+not running.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		*token = os.Getenv("GUM_ADMIN_TOKEN")
+	}
+
+	export := func(w io.Writer) error {
+		path := "/api/export?" + url.Values{"format": {*format}}.Encode()
+		return adminCall(*addr, *token, http.MethodGet, path, nil, w)
+	}
+	if *configFile != "" || *staticDir != "" || len(redirects) > 0 {
+		export = func(w io.Writer) error { return exportLocal(w, *format) }
+	}
+
+	if *output == "" {
+		return export(os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = export(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// exportLocal loads the handlers and stored mappings specified by the config
+// file and command line flags, and writes their redirects to w in format.
+func exportLocal(w io.Writer, format string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	g := gum.NewServer()
+	if err := configureServer(g, c); err != nil {
+		return err
+	}
+	if c.Store != "" {
+		store, err := gum.OpenBoltStore(c.Store)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := g.SetStore(store); err != nil {
+			return err
+		}
+	}
+	handlers, err := newHandlers(c)
+	if err != nil {
+		return err
+	}
+	if err := g.Reload(handlers...); err != nil {
+		return err
+	}
+	defer g.Close()
+	return g.Export(w, format)
+}
@@ -0,0 +1,32 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportLocal(t *testing.T) {
+	dir := t.TempDir()
+	html := `<link rel="canonical" href="https://example.com/post"><link rel="shortlink" href="/p">`
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(html), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d string) { *staticDir = d }(*staticDir)
+	*staticDir = dir
+
+	var buf bytes.Buffer
+	if err := exportLocal(&buf, "netlify"); err != nil {
+		t.Fatalf("exportLocal returned error: %v", err)
+	}
+	if got, want := buf.String(), "# Redirects exported from gum.\n/p  https://example.com/post  301\n"; got != want {
+		t.Errorf("exportLocal wrote %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"willnorris.com/go/gum"
+)
+
+// runGenerate implements the "gum generate" command, which asks a running gum
+// server to create a mapping with a generated short path for a permalink.
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	addr := fs.String("admin_addr", "localhost:4595", "TCP address of the gum admin API")
+	token := fs.String("admin_token", "", "bearer token for admin API requests (defaults to $GUM_ADMIN_TOKEN)")
+	host := fs.String("host", "", "restrict the new short URL to this host")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage:
+  gum generate [-admin_addr=<addr>] [-host=<host>] <permalink>
+
+Generate creates a new mapping for permalink using a generated short path, and
+prints the new short URL.  Gum must be running with the admin API enabled.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		*token = os.Getenv("GUM_ADMIN_TOKEN")
+	}
+
+	body, err := json.Marshal(map[string]string{"host": *host, "permalink": fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	var m gum.Mapping
+	if err := adminCall(*addr, *token, http.MethodPost, "/api/generate", bytes.NewReader(body), &m); err != nil {
+		return err
+	}
+	fmt.Println(m.Host + m.ShortPath)
+	return nil
+}
+
+// adminCall sends a request to the gum admin API at addr, and decodes the JSON
+// response into v, or copies the response to v if it is an io.Writer.
+func adminCall(addr, token, method, path string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, "http://"+addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("admin API returned %v", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if w, ok := v.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
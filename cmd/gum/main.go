@@ -9,18 +9,27 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"willnorris.com/go/gum"
 )
 
+// shutdownTimeout is how long to wait for in-flight requests to complete when
+// shutting down.
+const shutdownTimeout = 10 * time.Second
+
 // govvv values
 var (
 	Version    = "HEAD"
@@ -30,18 +39,36 @@ var (
 
 // Flags
 var (
-	addr      = flag.String("addr", "localhost:4594", "TCP address to listen on")
-	version   = flag.Bool("version", false, "print version information")
-	staticDir = flag.String("static_dir", "", "directory of static site to setup redirects for")
-	redirects redirectSlice
+	addr          = flag.String("addr", "localhost:4594", "TCP address to listen on")
+	version       = flag.Bool("version", false, "print version information")
+	configFile    = flag.String("config", "", "JSON config file of listeners and handlers")
+	staticDir     = flag.String("static_dir", "", "directory of static site to setup redirects for")
+	matchHost     = flag.Bool("static_hosts", false, "restrict static site redirects to the host of each shortlink")
+	staticWhistle = flag.String("static_whistle", "", "comma separated list of 'type=prefix' whistle content types to compute static site shortlinks for")
+	staticTombs   = flag.Bool("static_tombstones", false, "retire the shortlinks of deleted static site files with a 410 (Gone) response")
+	tombRetention = flag.Duration("tombstone_retention", 0, "how long to keep tombstones for retired short URLs (0 keeps them forever)")
+	gonePage      = flag.String("gone_page", "", "HTML file to serve for retired short URLs")
+	notFoundTmpl  = flag.String("not_found_template", "", "HTML template to serve for unmatched short URLs")
+	notFoundURL   = flag.String("not_found_redirect", "", "URL to redirect unmatched short URLs to, with {path} replaced by the request path")
+	notFoundProxy = flag.String("not_found_proxy", "", "base URL of an upstream server to proxy unmatched short URLs to")
+	strict        = flag.Bool("strict", false, "exit with an error if any short URLs have conflicting mappings at startup")
+	check         = flag.Bool("check", false, "load all handlers, report any conflicting mappings, and exit")
+	adminAddr     = flag.String("admin_addr", "", "TCP address to serve the admin API on")
+	adminToken    = flag.String("admin_token", "", "bearer token required for admin API requests (defaults to $GUM_ADMIN_TOKEN)")
+	storeFile     = flag.String("store", "", "database file to persist mappings created with the admin API")
+	trackClicks   = flag.Bool("clicks", false, "count clicks on short URLs, by day and referring host")
+	accessLogFile = flag.String("access_log", "", "file to log served requests to, or '-' for standard output")
+	accessFormat  = flag.String("access_log_format", "", "format of the access log, 'combined' (default) or 'json'")
+	serveHealth   = flag.Bool("health", false, "serve liveness and readiness checks at /healthz and /readyz")
+	healthAddr    = flag.String("health_addr", "", "TCP address to serve liveness and readiness checks on, rather than the -addr listener")
+	proxies       = flag.String("trusted_proxies", "", "comma separated list of IP addresses or CIDR networks of proxies whose X-Forwarded-For headers are trusted")
+	redirects     redirectSlice
+	rules         ruleSlice
 )
 
 func init() {
-	flag.Var(&redirects, "redirect", "redirect handler definition of the form 'prefix=destination'")
-}
-
-type redirect struct {
-	Prefix, Destination string
+	flag.Var(&redirects, "redirect", "redirect handler definition of the form '[//host/]prefix=[status:]destination'")
+	flag.Var(&rules, "rule", "redirect rule definition of the form '[//host]pattern=[status:]destination'")
 }
 
 type redirectSlice []redirect
@@ -55,19 +82,73 @@ func (r *redirectSlice) Set(value string) error {
 	if len(parts) != 2 {
 		return errors.New("redirect flag value should be of the form 'prefix=dest'")
 	}
-	if _, err := url.Parse(parts[1]); err != nil {
-		return fmt.Errorf("Destination %q is not a valid URL: %v", parts[1], err)
+	var host string
+	prefix := parts[0]
+	if strings.HasPrefix(prefix, "//") {
+		hostPrefix := strings.SplitN(prefix[2:], "/", 2)
+		if len(hostPrefix) != 2 || hostPrefix[0] == "" {
+			return errors.New("redirect flag value with a host should be of the form '//host/prefix=dest'")
+		}
+		host, prefix = hostPrefix[0], hostPrefix[1]
+	}
+	status, dest := splitStatus(parts[1])
+	rd := redirect{Host: host, Prefix: prefix, Destination: dest, Status: status}
+	if err := rd.validate(); err != nil {
+		return err
+	}
+	*r = append(*r, rd)
+	return nil
+}
+
+// splitStatus splits a flag destination of the form "[status:]destination"
+// into its status, or zero if none is specified, and destination.
+func splitStatus(value string) (int, string) {
+	if len(value) > 4 && value[3] == ':' {
+		if status, err := strconv.Atoi(value[:3]); err == nil {
+			return status, value[4:]
+		}
+	}
+	return 0, value
+}
+
+type ruleSlice []rule
+
+func (r *ruleSlice) String() string {
+	return fmt.Sprintf("%v", *r)
+}
+
+func (r *ruleSlice) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("rule flag value should be of the form 'pattern=dest'")
+	}
+	var host string
+	pattern := parts[0]
+	if strings.HasPrefix(pattern, "//") {
+		i := strings.Index(pattern[2:], "/")
+		if i <= 0 {
+			return errors.New("rule flag value with a host should be of the form '//host/pattern=dest'")
+		}
+		host, pattern = pattern[2:2+i], pattern[2+i:]
+	}
+	status, dest := splitStatus(parts[1])
+	rl := rule{Host: host, Pattern: pattern, Destination: dest, Status: status}
+	if err := rl.validate(); err != nil {
+		return err
 	}
-	*r = append(*r, redirect{Prefix: parts[0], Destination: parts[1]})
+	*r = append(*r, rl)
 	return nil
 }
 
 func usage() {
 	fmt.Print(`gum is a personal short URL resolver.
 Usage:
-  gum [-redirect=<redirect>] [-static_dir=<static_dir>]
+  gum [-config=<file>] [-redirect=<redirect>] [-rule=<rule>] [-static_dir=<static_dir>] [-static_hosts]
+  gum generate [-admin_addr=<addr>] [-host=<host>] <permalink>
+  gum export [-admin_addr=<addr> | -config=<file> | -static_dir=<dir>] [-format=<format>] [-o=<file>]
 
-Gum supports two styles of handlers, which are configured with command line flags:
+Gum supports two styles of handlers, which are configured with command line
+flags or a config file:
 
 Redirect Handlers are configured by providing a mapping of the form
 "prefix=dest" using the -redirect flag, which will redirect all URLs matching a
@@ -99,15 +180,208 @@ see.  For example, given an HTML file with the contents:
   </html>
 
 Requests whose path matched any of "/t123", "/b/123", or "/b/456" would be
-redirected to "http://example.com/post/12345678".  The static site handler
-currently ignores the hostname of the shortlink URL when setting up redirects.
+redirected to "http://example.com/post/12345678".  By default, the static site
+handler ignores the hostname of the shortlink URL when setting up redirects.
+If the -static_hosts flag is set, redirects will only apply to requests for the
+hostname of the shortlink ("x.com" in the example above).
+
+The static site handler can also compute shortlinks for each file using
+Tantek Çelik's Whistle scheme, from the content type of the page and the date
+it was published.  Content types are specified with the -static_whistle flag as
+a list of prefixes of canonical URL paths:
+
+  gum -static_dir=/var/www/public -static_whistle=b=/post/,t=/notes/
+
+This would redirect "/t4Uh2" to the second page under "/notes/" published on
+2014-02-21.  Publication dates are read from <time class="dt-published"> or
+<meta property="article:published_time"> elements.
+
+If the -static_tombstones flag is set, the shortlinks of deleted files are
+retired with a tombstone, which responds with 410 (Gone) rather than 404 (Not
+Found), unless another source maps the short URL again.  Tombstones are kept
+for the period given by the -tombstone_retention flag, or forever if it is
+zero, and the -gone_page flag specifies an HTML file to serve for retired
+short URLs.
+
+
+Gum can serve multiple short domains from a single instance.  Redirect handlers
+can be restricted to a single host by prefixing the handler definition with
+"//host/":
+
+  gum -redirect //a.example/x=http://example.com/ -redirect //b.example/x=/x/
+
+Handlers and static redirects for the requested host take precedence over
+those without a host.
+
+
+Redirect Rules are configured with the -rule flag, which maps request paths
+matching a pattern to a destination URL template.  Patterns contain named
+parameters in braces, which match a path segment or the regular expression
+given after a colon, and are substituted into the destination:
+
+  gum -rule '/i/{id:[0-9]+}=https://github.com/org/repo/issues/{id}'
+
+Patterns beginning with "^" are regular expressions, whose capture groups are
+substituted by number or name, such as "^/i/([0-9]+)$=/issues/{1}".  Rules are
+evaluated in the order they are specified, and the first matching rule is
+used.  Rules can be restricted to a host with a "//host" prefix.
+
+
+Rather than using command line flags, handlers can be configured in a JSON
+file specified with the -config flag.  For example:
+
+  {
+    "listen": ["localhost:4594"],
+    "static": [
+      {"dir": "/var/www/example.com/public", "match_host": false, "tombstones": true}
+    ],
+    "redirects": [
+      {"prefix": "x", "destination": "http://example.com/"},
+      {"host": "a.example", "prefix": "x", "destination": "/x/", "status": 302}
+    ],
+    "rules": [
+      {"pattern": "/i/{id:[0-9]+}", "destination": "https://github.com/org/repo/issues/{id}"}
+    ],
+    "mappings": [
+      {"short_path": "/gum", "permalink": "https://github.com/willnorris/gum"},
+      {"short_path": "/old", "status": 410}
+    ],
+    "tombstones": {"retention": "2160h", "page": "/var/www/gone.html"}
+  }
+
+The "mappings" list provides one-off redirects from a short path to a
+permalink, with an optional redirect "status".  A status of 410 retires the
+short path, and no permalink is needed.  Static files can likewise specify a
+status with a data-status attribute on their shortlink, and the -redirect and
+-rule flags accept a status before the destination, such as "x=302:/x/".
+
+Static directories, redirects, rules, and mappings can specify a "query"
+policy for the query string of requests: "preserve", "drop", "merge", or
+"allow" followed by parameter names, and any fixed "name=value" parameters to
+add, such as "merge utm_source=short".  Handlers specified by command line
+flags are added to those in the config file.  If the config file specifies
+listen addresses, the -addr flag is ignored.
+
+Mappings and redirect handlers can be managed at runtime using the JSON admin
+API, which is served on the address specified by the -admin_addr flag (or the
+"admin" config).  Requests must include the token specified by -admin_token or
+$GUM_ADMIN_TOKEN as a bearer token.  For example:
+
+  curl -H "Authorization: Bearer $GUM_ADMIN_TOKEN" localhost:4595/api/mappings \
+    -d '{"short_path": "/gum", "permalink": "https://github.com/willnorris/gum"}'
+
+The API provides /api/mappings, /api/redirects, and /api/conflicts endpoints,
+and /api/shorten?url=... lists the short URLs which redirect to a permalink.
+Prometheus metrics are served at /metrics.
+Redirect handlers created with the admin API are replaced when the config file
+is reloaded, while mappings are retained until gum is restarted.  To keep
+mappings across restarts, specify a database file with the -store flag (or the
+"store" config), which is created if it does not exist.
+
+New mappings with generated short paths can be created with the
+/api/generate endpoint, or with the "gum generate" command, which calls the
+admin API of a running gum server:
+
+  gum generate https://example.com/post/12345678
+
+Short paths are random codes of 5 base62 characters by default.  The
+"generator" config can specify the "alphabet" ("base62", "newbase60", or
+"crockford32"), "length", path "prefix", and a list of "reserved" codes that
+are never generated:
+
+  "generator": {"alphabet": "newbase60", "length": 4, "prefix": "/t", "reserved": ["api"]}
+
+If the -clicks flag (or "clicks" config) is set, clicks on short URLs are
+counted by day and referring host, except for requests with a "DNT: 1"
+header.  Clicks are written to the -store database every minute, and are
+reported by the /api/clicks endpoint, or the "gum clicks" command:
+
+  gum clicks -by referrer -from 2014-02-01
+
+The redirects served by gum can be exported with the /api/export endpoint, or
+the "gum export" command, as json or csv for auditing, or as nginx, apache,
+netlify, or caddy configuration which serves the same redirects.  Given a
+config file or static directory, it loads them itself rather than calling the
+admin API, so it also works while gum is not running:
+
+  gum export -format nginx -o /etc/nginx/gum-redirects.conf
+  gum export -config /etc/gum.json -format caddy
+
+If multiple sources map the same short URL to different permalinks, mappings
+from the config file take precedence, followed by the most recently modified
+static file.  Conflicting mappings are logged at startup.  The -strict flag
+causes gum to exit with an error if any conflicts are found, and the -check
+flag loads all handlers and reports conflicts without starting the server,
+which is useful for testing a static site.
+
+Requests which nothing matches are logged and respond with 404 (Not Found).
+Instead, the -not_found_template flag specifies an HTML template to serve
+with the .Host and .Path of the request, the -not_found_redirect flag
+specifies a URL to redirect to, in which "{path}" is replaced with the request
+path, and the -not_found_proxy flag specifies an upstream server to proxy
+requests to.  In the config file, these are given as the "template",
+"redirect", or "proxy" of the "not_found" config:
+
+  "not_found": {"redirect": "https://example.com/search?q={path}"}
+
+Any short URL can be previewed by adding a "preview" query parameter, such as
+/gum?preview, or for mappings, by appending "+" to the path, such as /gum+.
+Previews show the destination, status, and source of the short URL, and the
+title of static pages, rather than redirecting.
+
+Similarly, a QR code of any short URL is served by adding a "qr" query
+parameter, such as /gum?qr or /gum?qr=svg, or for mappings, by appending ".qr"
+to the path, such as /gum.qr.  The "size" parameter sets the image width in
+pixels, and the "ec" parameter sets the error correction level (L, M, Q, or H).
+
+The -access_log flag specifies a file to log served requests to, or "-" for
+standard output, in the combined log format followed by the matched short URL,
+destination, handler, and latency, or as JSON lines with -access_log_format
+json.  The -trusted_proxies flag lists the addresses of proxies whose
+X-Forwarded-For headers identify the client.  The access log file is reopened
+when gum receives a SIGUSR1 signal, such as after it has been rotated.  In the
+config file, these are given as the "path", "format", and "trusted_proxies" of
+the "access_log" config:
+
+  "access_log": {"path": "/var/log/gum/access.log", "format": "json"}
+
+The -health flag serves a liveness check at /healthz and a readiness check at
+/readyz, or the -health_addr flag serves them on a separate address.  Gum
+listens before loading handlers, and the readiness check responds with 503
+(Service Unavailable) until all handlers are loaded, or if a static directory
+watcher has failed.  In the config file, the "health" config can also specify
+the "liveness_path" and "readiness_path":
+
+  "health": {"listen": "localhost:4596", "readiness_path": "/ready"}
+
+The config file is reloaded whenever it changes or gum receives a SIGHUP
+signal.  On SIGINT or SIGTERM, gum stops accepting new connections and waits
+for in-flight requests to complete before exiting.
 
 Flags:
 `)
 	flag.PrintDefaults()
 }
 
+// subcommands are the commands which call the admin API of a running gum
+// server, or in the case of export, optionally load its config instead, keyed
+// by name.
+var subcommands = map[string]func(args []string) error{
+	"generate": runGenerate,
+	"clicks":   runClicks,
+	"export":   runExport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -120,35 +394,366 @@ func main() {
 		addr = &a
 	}
 
+	c, err := loadConfig()
+	if err != nil {
+		log.Fatal("error loading config: ", err)
+	}
+
 	g := gum.NewServer()
+	if err := configureServer(g, c); err != nil {
+		log.Fatal(err)
+	}
+	var store *gum.BoltStore
+	if c.Store != "" && !*check {
+		if store, err = gum.OpenBoltStore(c.Store); err != nil {
+			log.Fatal(err)
+		}
+		if err := g.SetStore(store); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var clicks *gum.ClickTracker
+	if c.Clicks && !*check {
+		var cs gum.ClickStore
+		if store != nil {
+			cs = store
+		}
+		clicks = gum.NewClickTracker(cs)
+		g.SetClickTracker(clicks)
+		go flushClicks(clicks)
+	}
+	var accessLog *gum.AccessLog
+	if !*check {
+		if accessLog, err = c.AccessLog.open(); err != nil {
+			log.Fatal(err)
+		}
+		if accessLog != nil {
+			g.SetAccessLog(accessLog)
+			go reopenAccessLog(accessLog)
+		}
+	}
 
-	for _, r := range redirects {
+	// start listening before loading handlers, so that health checks
+	// report that gum is not yet ready while large static sites load.
+	var servers []*http.Server
+	errc := make(chan error, len(c.Listen)+2)
+	serve := func(server *http.Server) {
+		servers = append(servers, server)
+		go func() { errc <- server.ListenAndServe() }()
+	}
+	if !*check {
+		var handler http.Handler = g
+		if c.Health != nil && c.Health.Listen == "" {
+			handler = c.Health.handler(g, g)
+		}
+		for _, addr := range c.Listen {
+			fmt.Printf("gum (%v) listening on %s\n", GitSummary, addr)
+			serve(&http.Server{Addr: addr, Handler: handler})
+		}
+		if c.Admin.Listen != "" {
+			admin := gum.NewAdminHandler(g, c.Admin.Token)
+			admin.Generator = c.Generator.generator()
+			fmt.Printf("gum admin API listening on %s\n", c.Admin.Listen)
+			serve(&http.Server{Addr: c.Admin.Listen, Handler: admin})
+		}
+		if c.Health != nil && c.Health.Listen != "" {
+			fmt.Printf("gum health checks listening on %s\n", c.Health.Listen)
+			serve(&http.Server{Addr: c.Health.Listen, Handler: c.Health.handler(g, nil)})
+		}
+	}
+
+	handlers, err := newHandlers(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := g.Reload(handlers...); err != nil {
+		log.Fatal(err)
+	}
+	if conflicts := logConflicts(g); conflicts > 0 && (*strict || *check) {
+		log.Fatalf("found %d conflicting short URLs", conflicts)
+	}
+	if *check {
+		g.Close()
+		return
+	}
+	go watchReload(g, c)
+	go expireTombstones(g)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		log.Fatal("ListenAndServe: ", err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down server on %s: %v", server.Addr, err)
+		}
+	}
+	if err := g.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down gum: %v", err)
+	}
+	if clicks != nil {
+		if err := clicks.Flush(); err != nil {
+			log.Printf("error flushing clicks: %v", err)
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("error closing store: %v", err)
+		}
+	}
+	if accessLog != nil {
+		if err := accessLog.Close(); err != nil {
+			log.Printf("error closing access log: %v", err)
+		}
+	}
+}
+
+// loadConfig loads the config file specified by the -config flag, if any, and
+// merges in the handlers specified by other command line flags.
+func loadConfig() (*config, error) {
+	c := new(config)
+	if *configFile != "" {
+		var err error
+		if c, err = readConfig(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Redirects = append(c.Redirects, redirects...)
+	c.Rules = append(c.Rules, rules...)
+	if *staticDir != "" {
+		d := staticSite{Dir: *staticDir, MatchHost: *matchHost, Tombstones: *staticTombs}
+		if *staticWhistle != "" {
+			types, err := parseWhistleTypes(*staticWhistle)
+			if err != nil {
+				return nil, err
+			}
+			d.Whistle = &whistle{Types: types}
+		}
+		if err := d.validate(); err != nil {
+			return nil, err
+		}
+		c.Static = append(c.Static, d)
+	}
+	if len(c.Listen) == 0 {
+		c.Listen = []string{*addr}
+	}
+	if *adminAddr != "" {
+		c.Admin.Listen = *adminAddr
+	}
+	if *storeFile != "" {
+		c.Store = *storeFile
+	}
+	if *trackClicks {
+		c.Clicks = true
+	}
+	if *tombRetention != 0 {
+		c.Tombstones.Retention = tombRetention.String()
+	}
+	if *gonePage != "" {
+		c.Tombstones.Page = *gonePage
+	}
+	if err := c.Tombstones.validate(); err != nil {
+		return nil, err
+	}
+	if *notFoundTmpl != "" || *notFoundURL != "" || *notFoundProxy != "" {
+		c.NotFound = notFound{Template: *notFoundTmpl, Redirect: *notFoundURL, Proxy: *notFoundProxy}
+		if err := c.NotFound.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if *accessLogFile != "" {
+		c.AccessLog.Path = *accessLogFile
+	}
+	if *accessFormat != "" {
+		c.AccessLog.Format = *accessFormat
+	}
+	if *proxies != "" {
+		c.AccessLog.TrustedProxies = strings.Split(*proxies, ",")
+	}
+	if err := c.AccessLog.validate(); err != nil {
+		return nil, err
+	}
+	if *serveHealth || *healthAddr != "" {
+		if c.Health == nil {
+			c.Health = new(health)
+		}
+		if *healthAddr != "" {
+			c.Health.Listen = *healthAddr
+		}
+	}
+	if *adminToken != "" {
+		c.Admin.Token = *adminToken
+	} else if token := os.Getenv("GUM_ADMIN_TOKEN"); token != "" && c.Admin.Token == "" {
+		c.Admin.Token = token
+	}
+	if err := c.Admin.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newHandlers constructs handlers for each redirect, static directory, and
+// mapping in c.
+func newHandlers(c *config) ([]gum.Handler, error) {
+	var handlers []gum.Handler
+
+	for _, r := range c.Redirects {
 		h, err := gum.NewRedirectHandler(r.Prefix, r.Destination)
 		if err != nil {
-			log.Fatal("error adding redirect handler: ", err)
+			return nil, fmt.Errorf("error adding redirect handler: %w", err)
+		}
+		h.Host = strings.ToLower(r.Host)
+		h.Query = r.Query
+		if r.Status != 0 {
+			h.Status = r.Status
 		}
-		if err := g.AddHandler(h); err != nil {
-			log.Fatal("error adding redirect handler: ", err)
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("error adding redirect handler: %w", err)
 		}
+		handlers = append(handlers, h)
 	}
 
-	if *staticDir != "" {
-		h, err := gum.NewStaticHandler(*staticDir)
+	// rules are grouped into a handler per host, so that each host's rules
+	// are evaluated in the order they were specified.
+	ruleHandlers := make(map[string]*gum.RuleHandler)
+	for _, r := range c.Rules {
+		rule, err := r.rule()
 		if err != nil {
-			log.Fatal("error adding static handler: ", err)
+			return nil, fmt.Errorf("error adding redirect rule: %w", err)
 		}
-		if err := g.AddHandler(h); err != nil {
-			log.Fatal("error adding static handler: ", err)
+		host := strings.ToLower(r.Host)
+		h := ruleHandlers[host]
+		if h == nil {
+			h = gum.NewRuleHandler()
+			h.Host = host
+			ruleHandlers[host] = h
+			handlers = append(handlers, h)
 		}
+		h.Rules = append(h.Rules, rule)
 	}
 
-	server := &http.Server{
-		Addr:    *addr,
-		Handler: g,
+	for _, d := range c.Static {
+		h, err := gum.NewStaticHandler(d.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("error adding static handler: %w", err)
+		}
+		h.MatchHost = d.MatchHost
+		h.Query = d.Query
+		h.Tombstones = d.Tombstones
+		if d.Whistle != nil {
+			h.Whistle = d.Whistle.whistle()
+		}
+		handlers = append(handlers, h)
 	}
 
-	fmt.Printf("gum (%v) listening on %s\n", GitSummary, server.Addr)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	if len(c.Mappings) > 0 {
+		var h mappingHandler
+		for _, m := range c.Mappings {
+			h = append(h, gum.Mapping{
+				Host:      m.Host,
+				ShortPath: m.ShortPath,
+				Permalink: m.Permalink,
+				Status:    m.Status,
+				Source:    gum.Source{Kind: gum.SourceConfig, Name: *configFile},
+				Query:     m.Query,
+			})
+		}
+		handlers = append(handlers, h)
+	}
+
+	return handlers, nil
+}
+
+// configureServer applies the server-wide settings in c to g.
+func configureServer(g *gum.Server, c *config) error {
+	retention, err := c.Tombstones.retention()
+	if err != nil {
+		return err
+	}
+	g.SetTombstoneRetention(retention)
+
+	var page []byte
+	if c.Tombstones.Page != "" {
+		if page, err = ioutil.ReadFile(c.Tombstones.Page); err != nil {
+			return fmt.Errorf("error reading gone page: %w", err)
+		}
+	}
+	g.SetGonePage(page)
+
+	notFound, err := c.NotFound.handler()
+	if err != nil {
+		return fmt.Errorf("error loading not found handler: %w", err)
+	}
+	g.SetNotFound(notFound)
+	return nil
+}
+
+// tombstoneExpiryInterval is how often expired tombstones are removed.
+const tombstoneExpiryInterval = time.Hour
+
+// expireTombstones periodically removes expired tombstones from g.  This
+// function does not return.
+func expireTombstones(g *gum.Server) {
+	for range time.Tick(tombstoneExpiryInterval) {
+		g.ExpireTombstones()
 	}
 }
+
+// clickFlushInterval is how often clicks are written to the store.
+const clickFlushInterval = time.Minute
+
+// flushClicks periodically writes the clicks counted by t to its store.  This
+// function does not return.
+func flushClicks(t *gum.ClickTracker) {
+	for range time.Tick(clickFlushInterval) {
+		if err := t.Flush(); err != nil {
+			log.Printf("error flushing clicks: %v", err)
+		}
+	}
+}
+
+// reopenAccessLog reopens the file of l whenever the process receives one of
+// reopenSignals, such as after the file has been rotated.  This function does
+// not return.
+func reopenAccessLog(l *gum.AccessLog) {
+	reopen := make(chan os.Signal, 1)
+	if len(reopenSignals) > 0 {
+		signal.Notify(reopen, reopenSignals...)
+	}
+	for range reopen {
+		log.Print("Reopening access log")
+		if err := l.Reopen(); err != nil {
+			log.Printf("error reopening access log: %v", err)
+		}
+	}
+}
+
+// logConflicts logs any short URLs with conflicting mappings in g, and returns
+// the number of conflicts found.
+func logConflicts(g *gum.Server) int {
+	conflicts := g.Conflicts()
+	for _, c := range conflicts {
+		log.Printf("Conflict: %v", c)
+	}
+	return len(conflicts)
+}
+
+// mappingHandler is a gum.Handler which provides a fixed list of mappings.
+type mappingHandler []gum.Mapping
+
+func (h mappingHandler) Register(*http.ServeMux) error { return nil }
+
+func (h mappingHandler) Mappings(mappings chan<- gum.Mapping) error {
+	for _, m := range h {
+		mappings <- m
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	fsnotify "gopkg.in/fsnotify.v1"
+	"willnorris.com/go/gum"
+)
+
+// reloadDelay is how long to wait after a change to the config file before
+// reloading it, allowing editors to finish writing the file.
+const reloadDelay = 100 * time.Millisecond
+
+// watchReload reloads the configuration of g whenever the process receives a
+// SIGHUP or the config file changes.  c is the initial configuration of g.
+// This function does not return.
+func watchReload(g *gum.Server, c *config) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	if *configFile != "" {
+		if err := watchConfig(*configFile, reload); err != nil {
+			log.Printf("error watching config file, reload with SIGHUP instead: %v", err)
+		}
+	}
+
+	for range reload {
+		log.Print("Reloading configuration")
+		nc, err := loadConfig()
+		if err != nil {
+			log.Printf("error reloading config: %v", err)
+			continue
+		}
+		handlers, err := newHandlers(nc)
+		if err != nil {
+			log.Printf("error reloading config: %v", err)
+			continue
+		}
+		if err := g.Reload(handlers...); err != nil {
+			log.Printf("error reloading config: %v", err)
+			continue
+		}
+		if err := configureServer(g, nc); err != nil {
+			log.Printf("error reloading config: %v", err)
+		}
+		logConflicts(g)
+		if !reflect.DeepEqual(nc.Listen, c.Listen) {
+			log.Printf("Listen addresses cannot be changed without restarting gum, still listening on %v", c.Listen)
+		}
+		if nc.Store != c.Store {
+			log.Printf("Store cannot be changed without restarting gum, still using %q", c.Store)
+		}
+		if nc.Clicks != c.Clicks {
+			log.Print("Click tracking cannot be enabled or disabled without restarting gum")
+		}
+		if !reflect.DeepEqual(nc.AccessLog, c.AccessLog) {
+			log.Print("Access log cannot be changed without restarting gum")
+		}
+		if !reflect.DeepEqual(nc.Health, c.Health) {
+			log.Print("Health checks cannot be changed without restarting gum")
+		}
+		if !reflect.DeepEqual(nc.Generator, c.Generator) {
+			log.Print("Generator cannot be changed without restarting gum")
+		}
+	}
+}
+
+// watchConfig sends on reload whenever the config file at path changes.
+func watchConfig(path string, reload chan<- os.Signal) error {
+	path = filepath.Clean(path)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the containing directory rather than the file itself, since
+	// many editors replace files rather than writing to them.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev := <-watcher.Events:
+				if filepath.Clean(ev.Name) != path || ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					select {
+					case reload <- syscall.SIGHUP:
+					default: // reload already pending
+					}
+				})
+			case err := <-watcher.Errors:
+				log.Printf("Config watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals are the signals which cause the access log to be reopened.
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
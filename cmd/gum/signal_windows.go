@@ -0,0 +1,13 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package main
+
+import "os"
+
+// reopenSignals are the signals which cause the access log to be reopened.
+// Windows has no SIGUSR1, so the access log is only opened at startup.
+var reopenSignals []os.Signal
@@ -0,0 +1,433 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats supported by Server.Export.
+const (
+	ExportJSON    = "json"
+	ExportCSV     = "csv"
+	ExportNginx   = "nginx"
+	ExportApache  = "apache"
+	ExportNetlify = "netlify"
+	ExportCaddy   = "caddy"
+)
+
+// ExportFormats lists the formats supported by Server.Export.
+var ExportFormats = []string{ExportJSON, ExportCSV, ExportNginx, ExportApache, ExportNetlify, ExportCaddy}
+
+// exportEntry is a redirect in the exported table of a Server.
+type exportEntry struct {
+	// Host is the host the redirect applies to, or empty for all hosts.
+	Host string `json:"host,omitempty"`
+
+	// Path is the short path of a mapping, or the path prefix of a
+	// RedirectHandler.
+	Path string `json:"path"`
+
+	// Prefix reports whether Path is a path prefix, in which case
+	// requests for paths below it are redirected below Destination.
+	Prefix bool `json:"prefix,omitempty"`
+
+	// Destination is the URL redirected to, including any fixed query
+	// parameters.  For retired short URLs, it is the permalink they used
+	// to redirect to, if known.
+	Destination string `json:"destination,omitempty"`
+
+	// Status is the HTTP status of the redirect, or 410 (Gone) for
+	// retired short URLs.
+	Status int `json:"status"`
+
+	// Query is the query policy of the redirect.
+	Query QueryPolicy `json:"query,omitempty"`
+
+	// Source describes the mapping or handler which serves the redirect.
+	Source string `json:"source"`
+
+	// base is the URL that the path below Path is appended to, for
+	// prefix redirects, and destQuery is the query appended after it.
+	base, destQuery string
+
+	// forward reports whether the request query is passed on to the
+	// destination.
+	forward bool
+}
+
+// gone reports whether e is for a retired short URL.
+func (e exportEntry) gone() bool {
+	return e.Status == http.StatusGone
+}
+
+// Export writes the table of redirects served by s to w in format, which is
+// one of ExportFormats.  The table consists of the mapping served for each
+// short URL, including retired short URLs, followed by the path prefixes of
+// RedirectHandlers, in the order of precedence used by s.  Other handlers,
+// such as RuleHandlers, are not exported.
+//
+// The JSON and CSV formats describe each redirect, including its source and
+// query policy.  The nginx, apache, netlify, and caddy formats are web server
+// configurations which serve the same redirects, for when gum is unavailable.
+// These approximate query policies: the request query is passed on unless the
+// mode is drop, and Netlify applies its own rules.
+func (s *Server) Export(w io.Writer, format string) error {
+	entries := s.exportEntries()
+	switch format {
+	case ExportJSON:
+		if entries == nil {
+			entries = []exportEntry{}
+		}
+		b, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	case ExportCSV:
+		return writeCSV(w, entries)
+	case ExportNginx:
+		return writeNginx(w, entries)
+	case ExportApache:
+		return writeApache(w, entries)
+	case ExportNetlify:
+		return writeNetlify(w, entries)
+	case ExportCaddy:
+		return writeCaddy(w, entries)
+	}
+	return fmt.Errorf("gum: unknown export format %q", format)
+}
+
+// exportEntries returns the table of redirects served by s, in order of
+// precedence.
+func (s *Server) exportEntries() []exportEntry {
+	s.mutex.RLock()
+	var mappings []Mapping
+	for _, m := range s.urls.mappings() {
+		if !s.expired(m) {
+			mappings = append(mappings, m)
+		}
+	}
+	handlers := append([]Handler(nil), s.handlers...)
+	s.mutex.RUnlock()
+
+	// mappings for a host take precedence over those for all hosts
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return mappings[i].Host != "" && mappings[j].Host == ""
+	})
+
+	var entries []exportEntry
+	for _, m := range mappings {
+		e := exportEntry{
+			Host:        m.Host,
+			Path:        m.ShortPath,
+			Destination: m.Permalink,
+			Status:      m.status(),
+			Query:       m.Query,
+			Source:      m.Source.String(),
+		}
+		if !e.gone() {
+			e.Destination = m.Query.redirectURL(m.Permalink, "", QueryDrop)
+			e.forward = forwardsQuery(m.Query, QueryDrop)
+		}
+		entries = append(entries, e)
+	}
+
+	// likewise, the ServeMux prefers handlers for a host, and then longer
+	// patterns
+	var redirects []*RedirectHandler
+	for _, h := range handlers {
+		if rh, ok := h.(*RedirectHandler); ok {
+			redirects = append(redirects, rh)
+		}
+	}
+	sort.SliceStable(redirects, func(i, j int) bool {
+		a, b := redirects[i], redirects[j]
+		if (a.Host == "") != (b.Host == "") {
+			return a.Host != ""
+		}
+		return len(a.Prefix) > len(b.Prefix)
+	})
+	for _, h := range redirects {
+		e := exportEntry{
+			Host:        strings.ToLower(h.Host),
+			Path:        "/" + strings.Trim(h.Prefix, "/"),
+			Prefix:      true,
+			Destination: h.destination(&url.URL{}),
+			Status:      h.Status,
+			Query:       h.Query,
+			Source:      "redirect " + h.Host + "/" + h.Prefix,
+			forward:     forwardsQuery(h.Query, QueryPreserve),
+		}
+		base := h.Destination.ResolveReference(&url.URL{Path: "./"})
+		base.RawQuery, base.Fragment = "", ""
+		e.base = base.String()
+		// the destination query may only apply to the prefix itself
+		if u, err := url.Parse(h.destination(&url.URL{Path: "_"})); err == nil {
+			e.destQuery = u.RawQuery
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// forwardsQuery reports whether the query policy p passes on the request
+// query, using def as the mode if p does not specify one.
+func forwardsQuery(p QueryPolicy, def QueryPolicy) bool {
+	qp, err := p.parse(def)
+	return err == nil && qp.mode != QueryDrop
+}
+
+// target returns the URL that requests for the path below the prefix of e
+// are redirected to, where rest is the web server's reference to that path.
+func (e exportEntry) target(rest string) string {
+	t := e.base + rest
+	if e.destQuery != "" {
+		t += "?" + e.destQuery
+	}
+	return t
+}
+
+// writeCSV writes entries to w as CSV, with a header row.
+func writeCSV(w io.Writer, entries []exportEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"host", "path", "prefix", "destination", "status", "query", "source"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Host, e.Path, strconv.FormatBool(e.Prefix), e.Destination,
+			strconv.Itoa(e.Status), string(e.Query), e.Source,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeNginx writes entries to w as nginx map blocks, which set $gum_redirect
+// and $gum_status from the host and path of the request.
+func writeNginx(w io.Writer, entries []exportEntry) error {
+	type rule struct {
+		key, value string
+		status     int
+	}
+	var rules []rule
+	statuses := make(map[int]bool)
+	for _, e := range entries {
+		host := `[^/]*`
+		if e.Host != "" {
+			host = regexp.QuoteMeta(e.Host)
+		}
+		args := ""
+		if e.forward {
+			args = "$is_args$args"
+		}
+		statuses[e.Status] = true
+
+		if !e.Prefix {
+			value := ""
+			if !e.gone() {
+				value = e.Destination + nginxArgs(e.Destination, args)
+			}
+			rules = append(rules, rule{"~^" + host + regexp.QuoteMeta(e.Path) + "$", value, e.Status})
+			continue
+		}
+		p := host + regexp.QuoteMeta(strings.TrimSuffix(e.Path, "/"))
+		rest := e.target("$1")
+		rules = append(rules,
+			rule{"~^" + p + "/?$", e.Destination + nginxArgs(e.Destination, args), e.Status},
+			rule{"~^" + p + "/(.+)$", rest + nginxArgs(rest, args), e.Status})
+	}
+
+	var codes []int
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "# Redirects exported from gum.  Include this file in the http block, and\n")
+	fmt.Fprint(bw, "# add the following to the server block:\n#\n")
+	for _, code := range codes {
+		if code == http.StatusGone {
+			fmt.Fprintf(bw, "#     if ($gum_status = %d) { return %d; }\n", code, code)
+		} else {
+			fmt.Fprintf(bw, "#     if ($gum_status = %d) { return %d $gum_redirect; }\n", code, code)
+		}
+	}
+	fmt.Fprint(bw, "\nmap $host$uri $gum_redirect {\n")
+	for _, r := range rules {
+		fmt.Fprintf(bw, "    %s %s;\n", nginxQuote(r.key), nginxQuote(r.value))
+	}
+	fmt.Fprint(bw, "}\n\nmap $host$uri $gum_status {\n")
+	for _, r := range rules {
+		fmt.Fprintf(bw, "    %s %d;\n", nginxQuote(r.key), r.status)
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
+
+// nginxArgs returns args, which pass on the request query, joined to dest.
+func nginxArgs(dest, args string) string {
+	if args != "" && strings.Contains(dest, "?") {
+		return "&$args"
+	}
+	return args
+}
+
+// nginxQuote quotes s as an nginx configuration string.
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// writeApache writes entries to w as mod_rewrite rules, which may be used in
+// the server configuration or an .htaccess file.
+func writeApache(w io.Writer, entries []exportEntry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "# Redirects exported from gum.\nRewriteEngine On\n")
+	for _, e := range entries {
+		fmt.Fprintln(bw)
+		// substitutions are escaped, other than references to the
+		// path below a prefix
+		var patterns, substitutions []string
+		if !e.Prefix {
+			patterns = []string{"^/?" + regexp.QuoteMeta(strings.TrimPrefix(e.Path, "/")) + "$"}
+			substitutions = []string{apacheEscape(e.Destination)}
+		} else {
+			p := regexp.QuoteMeta(strings.TrimPrefix(e.Path, "/"))
+			if p == "" {
+				patterns = []string{"^/?$", "^/?(.+)$"}
+			} else {
+				patterns = []string{"^/?" + p + "/?$", "^/?" + p + "/(.+)$"}
+			}
+			rest := apacheEscape(e.base) + "$1"
+			if e.destQuery != "" {
+				rest += "?" + apacheEscape(e.destQuery)
+			}
+			substitutions = []string{apacheEscape(e.Destination), rest}
+		}
+
+		for i, pattern := range patterns {
+			if e.Host != "" {
+				fmt.Fprintf(bw, "RewriteCond %%{HTTP_HOST} ^%s(:[0-9]+)?$ [NC]\n", regexp.QuoteMeta(e.Host))
+			}
+			if e.gone() {
+				fmt.Fprintf(bw, "RewriteRule %s - [G,L]\n", apacheQuote(pattern))
+				continue
+			}
+			flags := "QSD"
+			if e.forward {
+				flags = "QSA"
+			}
+			fmt.Fprintf(bw, "RewriteRule %s %s [R=%d,NE,L,%s]\n", apacheQuote(pattern), substitutions[i], e.Status, flags)
+		}
+	}
+	return bw.Flush()
+}
+
+// apacheEscape escapes the characters of s which are special in mod_rewrite
+// substitutions.
+func apacheEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`, `%`, `\%`, ` `, `%20`).Replace(s)
+}
+
+// apacheQuote quotes s as an Apache configuration argument, if necessary.
+func apacheQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return s
+}
+
+// writeNetlify writes entries to w as a Netlify _redirects file.  Retired
+// short URLs cannot be expressed, and are written as comments.
+func writeNetlify(w io.Writer, entries []exportEntry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "# Redirects exported from gum.\n")
+	for _, e := range entries {
+		path := (&url.URL{Path: e.Path}).EscapedPath()
+		var froms []string
+		if e.Host == "" {
+			froms = []string{path}
+		} else {
+			froms = []string{"http://" + e.Host + path, "https://" + e.Host + path}
+		}
+		for _, from := range froms {
+			if e.gone() {
+				fmt.Fprintf(bw, "# %s is gone (%d)\n", from, e.Status)
+				continue
+			}
+			fmt.Fprintf(bw, "%s  %s  %d\n", from, e.Destination, e.Status)
+			if e.Prefix {
+				fmt.Fprintf(bw, "%s/*  %s  %d\n", strings.TrimSuffix(from, "/"), e.target(":splat"), e.Status)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// writeCaddy writes entries to w as a Caddyfile route block, which may be
+// used within a site block.
+func writeCaddy(w io.Writer, entries []exportEntry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "# Redirects exported from gum.  Use within a site block.\nroute {\n")
+	n := 0
+	matcher := func(host, pattern string) string {
+		n++
+		name := fmt.Sprintf("gum%d", n)
+		if host == "" {
+			fmt.Fprintf(bw, "\t@%s path_regexp %s %s\n", name, name, caddyQuote(pattern))
+		} else {
+			fmt.Fprintf(bw, "\t@%s {\n\t\thost %s\n\t\tpath_regexp %s %s\n\t}\n", name, host, name, caddyQuote(pattern))
+		}
+		return name
+	}
+	redirect := func(name, dest string, e exportEntry) {
+		if e.gone() {
+			fmt.Fprintf(bw, "\trespond @%s %d\n", name, e.Status)
+			return
+		}
+		if e.forward {
+			if strings.Contains(dest, "?") {
+				dest += "&{query}"
+			} else {
+				dest += "{?query}"
+			}
+		}
+		fmt.Fprintf(bw, "\tredir @%s %s %d\n", name, caddyQuote(dest), e.Status)
+	}
+
+	for _, e := range entries {
+		if !e.Prefix {
+			redirect(matcher(e.Host, "^"+regexp.QuoteMeta(e.Path)+"$"), e.Destination, e)
+			continue
+		}
+		p := regexp.QuoteMeta(strings.TrimSuffix(e.Path, "/"))
+		redirect(matcher(e.Host, "^"+p+"/?$"), e.Destination, e)
+		name := matcher(e.Host, "^"+p+"/(.+)$")
+		redirect(name, e.target("{re."+name+".1}"), e)
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
+
+// caddyQuote quotes s as a Caddyfile token, if necessary.
+func caddyQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"") {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+	return s
+}
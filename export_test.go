@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportServer returns a server with mappings and redirect handlers to
+// export.
+func exportServer(t *testing.T) *Server {
+	g := NewServer()
+	for _, m := range []Mapping{
+		{ShortPath: "/a", Permalink: "https://example.com/a", Query: "merge utm_source=short"},
+		{Host: "s.example", ShortPath: "/b", Permalink: "/pb"},
+		{ShortPath: "/g", Permalink: "/old", Status: http.StatusGone},
+	} {
+		if err := g.AddMapping(m); err != nil {
+			t.Fatalf("AddMapping returned error: %v", err)
+		}
+	}
+	w, _ := NewRedirectHandler("w", "https://en.wikipedia.org/wiki/")
+	s, _ := NewRedirectHandler("s", "https://x.example/search?q=1")
+	s.Host, s.Query = "s.example", QueryDrop
+	rule, _ := NewRule("/i/{id}", "/issues/{id}")
+	if err := g.Reload(w, s, NewRuleHandler(rule)); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	return g
+}
+
+func TestServer_Export_JSON(t *testing.T) {
+	g := exportServer(t)
+	g.SetTombstoneRetention(time.Hour)
+	g.AddMapping(Mapping{ShortPath: "/x", Permalink: "/px"}.tombstone(time.Now().Add(-2 * time.Hour)))
+
+	var buf bytes.Buffer
+	if err := g.Export(&buf, ExportJSON); err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	var entries []exportEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatalf("error decoding export: %v", err)
+	}
+
+	// mappings and redirect handlers for a host come first, and expired
+	// tombstones and rules are not exported
+	want := []exportEntry{
+		{Host: "s.example", Path: "/b", Destination: "/pb", Status: 301, Source: "manual"},
+		{Path: "/a", Destination: "https://example.com/a?utm_source=short", Status: 301, Query: "merge utm_source=short", Source: "manual"},
+		{Path: "/g", Destination: "/old", Status: 410, Source: "manual"},
+		{Host: "s.example", Path: "/s", Prefix: true, Destination: "https://x.example/search?q=1", Status: 301, Query: "drop", Source: "redirect s.example/s"},
+		{Path: "/w", Prefix: true, Destination: "https://en.wikipedia.org/wiki/", Status: 301, Source: "redirect /w"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Export returned:\n%v\nwant:\n%v", entries, want)
+	}
+
+	buf.Reset()
+	if err := NewServer().Export(&buf, ExportJSON); err != nil || buf.String() != "[]\n" {
+		t.Errorf("Export of empty server returned %q, %v", buf.String(), err)
+	}
+}
+
+func TestServer_Export_CSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportServer(t).Export(&buf, ExportCSV); err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading CSV: %v", err)
+	}
+	want := [][]string{
+		{"host", "path", "prefix", "destination", "status", "query", "source"},
+		{"s.example", "/b", "false", "/pb", "301", "", "manual"},
+	}
+	if len(records) != 6 || !reflect.DeepEqual(records[:2], want) {
+		t.Errorf("Export returned %v, want 6 records beginning with %v", records, want)
+	}
+}
+
+func TestServer_Export_Config(t *testing.T) {
+	g := exportServer(t)
+	tests := []struct {
+		format   string
+		contains []string
+	}{
+		{ExportNginx, []string{
+			`#     if ($gum_status = 301) { return 301 $gum_redirect; }`,
+			`#     if ($gum_status = 410) { return 410; }`,
+			`"~^s\\.example/b$" "/pb";`,
+			`"~^[^/]*/a$" "https://example.com/a?utm_source=short&$args";`,
+			`"~^[^/]*/g$" "";`,
+			`"~^s\\.example/s/(.+)$" "https://x.example/$1?q=1";`,
+			`"~^[^/]*/w/?$" "https://en.wikipedia.org/wiki/$is_args$args";`,
+			`"~^[^/]*/w/(.+)$" "https://en.wikipedia.org/wiki/$1$is_args$args";`,
+			`"~^[^/]*/g$" 410;`,
+		}},
+		{ExportApache, []string{
+			"RewriteCond %{HTTP_HOST} ^s\\.example(:[0-9]+)?$ [NC]\nRewriteRule ^/?b$ /pb [R=301,NE,L,QSD]\n",
+			"RewriteRule ^/?a$ https://example.com/a?utm_source=short [R=301,NE,L,QSA]\n",
+			"RewriteRule ^/?g$ - [G,L]\n",
+			"RewriteRule ^/?s/(.+)$ https://x.example/$1?q=1 [R=301,NE,L,QSD]\n",
+			"RewriteRule ^/?w/?$ https://en.wikipedia.org/wiki/ [R=301,NE,L,QSA]\n",
+			"RewriteRule ^/?w/(.+)$ https://en.wikipedia.org/wiki/$1 [R=301,NE,L,QSA]\n",
+		}},
+		{ExportNetlify, []string{
+			"http://s.example/b  /pb  301\nhttps://s.example/b  /pb  301\n",
+			"\n/a  https://example.com/a?utm_source=short  301\n",
+			"# /g is gone (410)\n",
+			"https://s.example/s/*  https://x.example/:splat?q=1  301\n",
+			"\n/w  https://en.wikipedia.org/wiki/  301\n/w/*  https://en.wikipedia.org/wiki/:splat  301\n",
+		}},
+		{ExportCaddy, []string{
+			"\t@gum1 {\n\t\thost s.example\n\t\tpath_regexp gum1 ^/b$\n\t}\n\tredir @gum1 /pb 301\n",
+			"\tredir @gum2 https://example.com/a?utm_source=short&{query} 301\n",
+			"\trespond @gum3 410\n",
+			"\tredir @gum5 https://x.example/{re.gum5.1}?q=1 301\n",
+			"\t@gum7 path_regexp gum7 ^/w/(.+)$\n\tredir @gum7 https://en.wikipedia.org/wiki/{re.gum7.1}{?query} 301\n",
+		}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := g.Export(&buf, tt.format); err != nil {
+			t.Fatalf("Export(%q) returned error: %v", tt.format, err)
+		}
+		got := buf.String()
+		for _, want := range tt.contains {
+			if !strings.Contains(got, want) {
+				t.Errorf("Export(%q) does not contain %q:\n%s", tt.format, want, got)
+			}
+		}
+		if strings.Contains(got, "issues") {
+			t.Errorf("Export(%q) includes rule:\n%s", tt.format, got)
+		}
+	}
+
+	if err := g.Export(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("Export(%q) did not return expected error", "xml")
+	}
+}
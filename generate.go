@@ -0,0 +1,177 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Alphabets which can be used to generate short codes.
+const (
+	// Base62 consists of the digits and upper and lower case letters.
+	Base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// NewBase60 is the alphabet of Tantek Çelik's NewBase60, which omits
+	// characters easily confused with one another, such as "l" and "1".
+	// See http://tantek.pbworks.com/w/page/19402946/NewBase60
+	NewBase60 = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ_abcdefghijkmnopqrstuvwxyz"
+
+	// Crockford32 is Douglas Crockford's base32 alphabet, in lower case.
+	// It omits easily confused letters.  Short paths are matched case
+	// sensitively, so short codes using it must still be entered in lower
+	// case.  See https://www.crockford.com/base32.html
+	Crockford32 = "0123456789abcdefghjkmnpqrstvwxyz"
+)
+
+// maxAttempts is the number of short codes a Generator tries before giving up.
+const maxAttempts = 100
+
+// ErrNoCode is returned when a Generator is unable to find an unused short
+// code, typically because Length is too small.
+var ErrNoCode = errors.New("gum: unable to generate an unused short code")
+
+// Generator generates random short codes for new mappings.
+type Generator struct {
+	// Alphabet is the set of characters short codes are made of.  If
+	// empty, Base62 is used.
+	Alphabet string
+
+	// Length is the number of characters in each short code.  If zero,
+	// DefaultLength is used.
+	Length int
+
+	// Prefix is prepended to each short code to form the short path.  If
+	// empty, "/" is used.
+	Prefix string
+
+	// Reserved is a list of short codes which are never generated, such as
+	// words that should not be used as short links.  Reserved codes are
+	// matched without regard to case.
+	Reserved []string
+}
+
+// DefaultLength is the length of short codes generated by a Generator which
+// does not specify one.
+const DefaultLength = 5
+
+func (g *Generator) alphabet() string {
+	if g.Alphabet == "" {
+		return Base62
+	}
+	return g.Alphabet
+}
+
+func (g *Generator) length() int {
+	if g.Length == 0 {
+		return DefaultLength
+	}
+	return g.Length
+}
+
+func (g *Generator) prefix() string {
+	if g.Prefix == "" {
+		return "/"
+	}
+	return g.Prefix
+}
+
+// Validate returns an error if g is not a usable generator.
+func (g *Generator) Validate() error {
+	alphabet := g.alphabet()
+	if len(alphabet) < 2 {
+		return fmt.Errorf("gum: generator alphabet %q should have at least two characters", alphabet)
+	}
+	for i, c := range alphabet {
+		if c > '~' || c <= ' ' || strings.ContainsRune("/?#%", c) {
+			return fmt.Errorf("gum: generator alphabet contains invalid character %q", c)
+		}
+		if strings.IndexRune(alphabet, c) != i {
+			return fmt.Errorf("gum: generator alphabet contains duplicate character %q", c)
+		}
+	}
+	if g.Length < 0 {
+		return fmt.Errorf("gum: generator length %d should be positive", g.Length)
+	}
+	if !strings.HasPrefix(g.prefix(), "/") {
+		return fmt.Errorf("gum: generator prefix %q should begin with a slash", g.Prefix)
+	}
+	return nil
+}
+
+// Code returns a random short code.  Reserved codes and existing mappings are
+// not considered.
+func (g *Generator) Code() (string, error) {
+	alphabet := g.alphabet()
+	max := big.NewInt(int64(len(alphabet)))
+	code := make([]byte, g.length())
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// reserved reports whether code is one of the generator's reserved codes.
+func (g *Generator) reserved(code string) bool {
+	for _, r := range g.Reserved {
+		if strings.EqualFold(code, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate adds a manual mapping to s from a newly generated short code to
+// permalink, and returns the new mapping.  Short codes which are reserved, or
+// which are already in use for host by a mapping or a handler such as a
+// RedirectHandler or RuleHandler, are skipped.  If no unused short code is
+// found, ErrNoCode is returned.
+func (g *Generator) Generate(s *Server, host, permalink string) (Mapping, error) {
+	if err := g.Validate(); err != nil {
+		return Mapping{}, err
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		code, err := g.Code()
+		if err != nil {
+			return Mapping{}, err
+		}
+		if g.reserved(code) {
+			continue
+		}
+		m := Mapping{
+			Host:      host,
+			ShortPath: g.prefix() + code,
+			Permalink: permalink,
+			Source:    Source{Kind: SourceManual},
+		}
+		r := &http.Request{Method: "GET", Host: host, URL: &url.URL{Path: m.ShortPath}}
+		if _, _, ok := s.describe(r); ok {
+			continue
+		}
+
+		err = s.AddMapping(m)
+		var cerr *ConflictError
+		if errors.As(err, &cerr) {
+			// the short code was taken since it was looked up
+			continue
+		} else if err != nil {
+			return Mapping{}, err
+		}
+		return m, nil
+	}
+	return Mapping{}, ErrNoCode
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerator_Code(t *testing.T) {
+	tests := []struct {
+		g      Generator
+		length int
+	}{
+		{Generator{}, DefaultLength},
+		{Generator{Alphabet: NewBase60, Length: 3}, 3},
+		{Generator{Alphabet: Crockford32, Length: 8}, 8},
+	}
+
+	for _, tt := range tests {
+		code, err := tt.g.Code()
+		if err != nil {
+			t.Fatalf("%+v.Code() returned error: %v", tt.g, err)
+		}
+		if len(code) != tt.length {
+			t.Errorf("%+v.Code() returned %q, want length %d", tt.g, code, tt.length)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(tt.g.alphabet(), c) {
+				t.Errorf("%+v.Code() returned %q, which contains %q not in alphabet", tt.g, code, c)
+			}
+		}
+	}
+}
+
+func TestGenerator_Validate(t *testing.T) {
+	tests := []struct {
+		g     Generator
+		valid bool
+	}{
+		{Generator{}, true},
+		{Generator{Alphabet: Base62}, true},
+		{Generator{Alphabet: NewBase60}, true},
+		{Generator{Alphabet: Crockford32}, true},
+		{Generator{Alphabet: "a"}, false},
+		{Generator{Alphabet: "aba"}, false},
+		{Generator{Alphabet: "ab/"}, false},
+		{Generator{Alphabet: "ab c"}, false},
+		{Generator{Length: -1}, false},
+		{Generator{Prefix: "/t"}, true},
+		{Generator{Prefix: "t"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.g.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v.Validate() returned %v, want valid %v", tt.g, err, tt.valid)
+		}
+	}
+}
+
+func TestGenerator_Generate(t *testing.T) {
+	g := NewServer()
+	gen := &Generator{Alphabet: "abc", Length: 1, Prefix: "/t", Reserved: []string{"A"}}
+
+	// "/tb" is taken for all hosts, and "/tc" only for x.example
+	g.AddMapping(Mapping{ShortPath: "/tb", Permalink: "/b"})
+	g.AddMapping(Mapping{Host: "x.example", ShortPath: "/tc", Permalink: "/c"})
+
+	m, err := gen.Generate(g, "", "/new")
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if want := (Mapping{ShortPath: "/tc", Permalink: "/new", Source: Source{Kind: SourceManual}}); m != want {
+		t.Errorf("Generate returned %v, want %v", m, want)
+	}
+	if got, _ := g.Lookup("", "/tc"); got != m {
+		t.Errorf("Lookup returned %v, want %v", got, m)
+	}
+
+	// all codes are now reserved or taken
+	if _, err := gen.Generate(g, "", "/other"); !errors.Is(err, ErrNoCode) {
+		t.Errorf("Generate returned error %v, want %v", err, ErrNoCode)
+	}
+	if _, err := gen.Generate(g, "x.example", "/other"); !errors.Is(err, ErrNoCode) {
+		t.Errorf("Generate for host returned error %v, want %v", err, ErrNoCode)
+	}
+}
+
+func TestGenerator_Generate_Handlers(t *testing.T) {
+	g := NewServer()
+	gen := &Generator{Alphabet: "abc", Length: 1}
+
+	// "/a" is handled by a redirect handler, and "/b" by a rule
+	rh, _ := NewRedirectHandler("a", "https://x.example/")
+	rule, err := NewRule("/{id:b}", "/issues/{id}")
+	if err != nil {
+		t.Fatalf("NewRule returned error: %v", err)
+	}
+	if err := g.Reload(rh, NewRuleHandler(rule)); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	m, err := gen.Generate(g, "", "/new")
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if m.ShortPath != "/c" {
+		t.Errorf("Generate returned short path %q, want %q", m.ShortPath, "/c")
+	}
+	if _, err := gen.Generate(g, "", "/other"); !errors.Is(err, ErrNoCode) {
+		t.Errorf("Generate returned error %v, want %v", err, ErrNoCode)
+	}
+}
@@ -4,7 +4,8 @@ go 1.16
 
 require (
 	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/net v0.0.0-20190420063019-afa5a82059c6
-	golang.org/x/sys v0.0.0-20190419153524-e8e3143a4f4a // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
 	gopkg.in/fsnotify.v1 v1.4.7
 )
@@ -8,105 +8,836 @@
 package gum // import "willnorris.com/go/gum"
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Server is a short URL redirection server.
 type Server struct {
+	// handlerMutex serializes changes to the server's set of handlers
+	handlerMutex sync.Mutex
+
+	// writeMutex serializes changes to the table of short URLs and the
+	// store, so that they are written to the store in the order they are
+	// applied without holding mutex during slow store writes.  If both are
+	// held, writeMutex must be acquired first.
+	writeMutex sync.Mutex
+
+	// mutex is a read/write lock for accessing the fields below
+	mutex sync.RWMutex
+
 	// ServeMux which handles all incoming requests
 	mux *http.ServeMux
 
-	// mutex is a read/write lock for accessing the urls map
-	mutex sync.RWMutex
-	// map of short URL paths to destinations
-	urls map[string]string
+	// table of short URLs and their mappings
+	urls *table
 
 	// channel of static mappings of short URLs and their destinations.
 	// Handlers can write to this channel to register new mappings; the
 	// Server will read from this channel and handle serving the redirects.
 	mappings chan Mapping
+
+	// handlers which have been added to the server
+	handlers []Handler
+
+	// whether the server has been closed
+	closed bool
+
+	// readers tracks running readMappings goroutines
+	readers sync.WaitGroup
+
+	// store persists manual mappings and tombstones, if set.  It is
+	// guarded by both writeMutex and mutex, so holding either is enough to
+	// read it.
+	store Store
+
+	// how long tombstones are kept, or zero to keep them forever
+	tombstoneRetention time.Duration
+
+	// optional HTML page served for retired short URLs
+	gonePage []byte
+
+	// optional handler for requests which nothing matches
+	notFound http.Handler
+
+	// optional tracker of clicks on short URLs
+	clicks *ClickTracker
+
+	// optional log of served requests
+	accessLog *AccessLog
+
+	// whether handlers have been loaded and the server is not closed
+	ready bool
 }
 
+// ErrServerClosed is returned when adding handlers to a Server after it has
+// been closed.
+var ErrServerClosed = errors.New("gum: Server closed")
+
 // NewServer constructs a new Server.
 func NewServer() *Server {
 	s := &Server{
 		mux:      http.NewServeMux(),
-		urls:     make(map[string]string),
+		urls:     newTable(),
 		mappings: make(chan Mapping),
 	}
 
-	go s.readMappings()
+	s.readers.Add(1)
+	go s.readMappings(s.mappings, s.urls)
 
 	return s
 }
 
-// ServeHTTP implements http.Handler.
+// ServeHTTP implements http.Handler.  Requests which do not match any mapping
+// or handler are served by NotFound.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if ok := s.redirect(w, r); ok {
+	s.mutex.RLock()
+	l := s.accessLog
+	s.mutex.RUnlock()
+
+	if l == nil {
+		s.serve(w, r, new(accessEntry))
 		return
 	}
+	start := time.Now()
+	rec := &responseRecorder{ResponseWriter: w}
+	e := new(accessEntry)
+	s.serve(rec, r, e)
+	l.logRequest(r, rec, *e, start)
+}
 
-	s.mux.ServeHTTP(w, r)
+// serve the request with the matching mapping or handler, describing how it
+// was served in e.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, e *accessEntry) {
+	if pr, _, ok := s.inspectRequest(r, "preview", "+"); ok {
+		s.servePreview(w, pr, e)
+		return
+	}
+	if qr, format, ok := s.inspectRequest(r, "qr", ".qr"); ok {
+		s.serveQR(w, qr, format, e)
+		return
+	}
+	if m, ok := s.redirect(w, r); ok {
+		e.handler, e.match = "mapping", m.Host+m.ShortPath
+		if m.status() == http.StatusGone {
+			e.handler = "gone"
+		}
+		return
+	}
+
+	s.mutex.RLock()
+	mux := s.mux
+	s.mutex.RUnlock()
+
+	ctx := context.WithValue(r.Context(), serverKey{}, s)
+	r = r.WithContext(context.WithValue(ctx, accessKey{}, e))
+	h, pattern := mux.Handler(r)
+	if pattern == "" {
+		NotFound(w, r)
+		return
+	}
+	e.handler, e.match = handlerName(h), pattern
+	mux.ServeHTTP(w, r)
 }
 
-// redirect the request if a matching URL mapping has been configured.  If no
-// mapping is found, a 404 status is returned.
-func (s *Server) redirect(w http.ResponseWriter, r *http.Request) bool {
+// SetNotFound sets the handler for requests which do not match any mapping or
+// handler, such as NotFoundTemplate, NotFoundRedirect, or NotFoundProxy.  If
+// h is nil, a plain 404 (Not Found) response is served.
+func (s *Server) SetNotFound(h http.Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notFound = h
+}
+
+// redirect the request if a matching URL mapping has been configured,
+// returning the mapping.  If no mapping is found, redirect returns false.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request) (Mapping, bool) {
+	m, ok := s.Lookup(r.Host, r.URL.Path)
+	if !ok {
+		return Mapping{}, false
+	}
+	if m.status() == http.StatusGone {
+		metrics.gone.inc()
+		s.serveGone(w, r)
+		return m, true
+	}
+	metrics.redirects.inc("handler", "mapping", "source", m.Source.Kind.String())
+	s.recordClick(r, m.Host, m.ShortPath)
+	dest := m.Query.redirectURL(m.Permalink, r.URL.RawQuery, QueryDrop)
+	http.Redirect(w, r, dest, m.status())
+	return m, true
+}
+
+// serveGone responds to a request for a retired short URL with a 410 (Gone)
+// status and the server's gone page, if set.
+func (s *Server) serveGone(w http.ResponseWriter, r *http.Request) {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	page := s.gonePage
+	s.mutex.RUnlock()
 
-	if url, ok := s.urls[r.URL.Path]; ok && url != "" {
-		http.Redirect(w, r, url, http.StatusMovedPermanently)
-		return true
+	if page == nil {
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+	w.Write(page)
+}
+
+// SetGonePage sets the HTML page served for short URLs which have been
+// retired, such as tombstones and mappings with a 410 (Gone) status.  If page
+// is nil, a plain text response is served.
+func (s *Server) SetGonePage(page []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gonePage = page
+}
 
-	return false
+// SetTombstoneRetention sets how long tombstones are served after their short
+// URL is retired.  Expired tombstones are no longer served, and are removed
+// by ExpireTombstones.  If d is zero, tombstones are kept forever.
+func (s *Server) SetTombstoneRetention(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tombstoneRetention = d
 }
 
-// readMappings reads values off the s.mappings channel and uses them to
-// populate s.urls.  This method does not return.
-func (s *Server) readMappings() {
-	for {
-		m := <-s.mappings
+// ExpireTombstones removes tombstones which have exceeded the server's
+// retention period, including from the server's store, and returns the number
+// of tombstones removed.
+func (s *Server) ExpireTombstones() int {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
 
-		s.mutex.Lock()
-		if old, exists := s.urls[m.ShortPath]; exists {
-			if m.Permalink == "" {
-				log.Printf("Deleting mapping: %v", m.ShortPath)
-				delete(s.urls, m.ShortPath)
-			} else if m.Permalink != old {
-				log.Printf("Overwriting mapping: %v => %v (previously %q)", m.ShortPath, m.Permalink, old)
-				s.urls[m.ShortPath] = m.Permalink
+	s.mutex.Lock()
+	if s.tombstoneRetention == 0 {
+		s.mutex.Unlock()
+		return 0
+	}
+	expired := s.urls.expire(time.Now().Add(-s.tombstoneRetention))
+	var stored []Mapping
+	for _, m := range expired {
+		// the store holds a single mapping per short URL, which is
+		// only the tombstone if there is no manual mapping.
+		if !s.urls.hasKind(m.key(), SourceManual) {
+			stored = append(stored, m)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, m := range stored {
+		if err := s.unpersist(m.Host, m.ShortPath); err != nil {
+			log.Print(err)
+		}
+	}
+	return len(expired)
+}
+
+// expired reports whether m is a tombstone which has exceeded the server's
+// retention period.  The caller must hold s.mutex.
+func (s *Server) expired(m Mapping) bool {
+	return m.isTombstone() && s.tombstoneRetention > 0 &&
+		time.Since(m.Source.Modified) > s.tombstoneRetention
+}
+
+// stripPort returns host without any port number.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// readMappings reads values off the mappings channel and uses them to
+// populate urls.  This method returns when mappings is closed.
+func (s *Server) readMappings(mappings <-chan Mapping, urls *table) {
+	defer s.readers.Done()
+	for m := range mappings {
+		if m == (Mapping{}) {
+			// zero mappings are sent by addHandler to wait for
+			// previous mappings to be applied.
+			continue
+		}
+
+		s.writeMutex.Lock()
+		if m.isDeletion() {
+			metrics.mappingUpdates.inc("op", "delete")
+		} else {
+			metrics.mappingUpdates.inc("op", "set")
+			s.mutex.RLock()
+			manual := urls.hasKind(m.key(), SourceManual)
+			s.mutex.RUnlock()
+			if m.isTombstone() && !manual {
+				// keep tombstones across restarts, unless they
+				// would replace a stored manual mapping.
+				if err := s.persist(m); err != nil {
+					log.Print(err)
+				}
 			}
+		}
+
+		s.mutex.Lock()
+		if m.isDeletion() {
+			urls.delete(m)
 		} else {
-			log.Printf("New mapping: %-7v => %v", m.ShortPath, m.Permalink)
-			s.urls[m.ShortPath] = m.Permalink
+			urls.set(m)
 		}
 		s.mutex.Unlock()
+		s.writeMutex.Unlock()
+	}
+}
+
+// ErrNotFound is returned when removing a mapping or handler that does not
+// exist.
+var ErrNotFound = errors.New("gum: not found")
+
+// A ConflictError is returned when adding a mapping for a short URL that is
+// already mapped to a different permalink.
+type ConflictError struct {
+	// Existing is the mapping already registered for the short URL.
+	Existing Mapping
+
+	// Mapping is the mapping that could not be added.
+	Mapping Mapping
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("gum: %v is already mapped to %q", e.Existing.key(), e.Existing.Permalink)
+}
+
+// AddMapping adds m to the server's mappings.  If m does not specify a source,
+// it is treated as coming from SourceManual.  If the short URL of m is already
+// mapped to a different permalink by any source, a *ConflictError is returned
+// and the existing mapping is left unchanged.  Tombstones are not conflicts,
+// as with Conflicts, so retired short URLs can be mapped again.  Unlike
+// mappings provided by handlers, changes are applied before AddMapping
+// returns.
+func (s *Server) AddMapping(m Mapping) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if m.Source.Kind == SourceUnknown {
+		m.Source.Kind = SourceManual
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.mutex.RLock()
+	for _, old := range s.urls.urls[m.key()] {
+		if !old.sameTarget(m) && !old.isTombstone() {
+			s.mutex.RUnlock()
+			return &ConflictError{Existing: old, Mapping: m}
+		}
+	}
+	s.mutex.RUnlock()
+
+	if err := s.persist(m); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.urls.set(m)
+	s.mutex.Unlock()
+	return nil
+}
+
+// SetMapping adds m to the server's mappings, replacing any existing mapping
+// for the same short URL from the same source.  If m does not specify a
+// source, it is treated as coming from SourceManual.  Unlike AddMapping, m is
+// added even if other sources map the short URL to a different permalink, in
+// which case the mapping with the highest precedence is used.
+func (s *Server) SetMapping(m Mapping) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if m.Source.Kind == SourceUnknown {
+		m.Source.Kind = SourceManual
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := s.persist(m); err != nil {
+		return err
 	}
+	s.mutex.Lock()
+	s.urls.set(m)
+	s.mutex.Unlock()
+	return nil
+}
+
+// persist writes m to the server's store if it is a manual mapping or a
+// tombstone.  The caller must hold s.writeMutex, and not s.mutex.
+func (s *Server) persist(m Mapping) error {
+	if s.store == nil || (m.Source.Kind != SourceManual && !m.isTombstone()) {
+		return nil
+	}
+	if err := s.store.Put(m); err != nil {
+		return fmt.Errorf("gum: error storing mapping %v: %w", m.key(), err)
+	}
+	return nil
+}
+
+// RemoveMapping removes the mappings from all sources for the short URL with
+// the specified host and path.  Unlike Lookup, host must exactly match that
+// of the mapping, with an empty host identifying mappings for all hosts.  If
+// no such mapping exists, ErrNotFound is returned.  Mappings from handlers will
+// be added again if the handler provides them again, such as when a static
+// file is modified.
+func (s *Server) RemoveMapping(host, shortPath string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	key := Mapping{Host: host, ShortPath: shortPath}.key()
+	s.mutex.RLock()
+	_, ok := s.urls.get(key)
+	s.mutex.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.unpersist(host, shortPath); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.urls.deleteKey(key)
+	s.mutex.Unlock()
+	return nil
+}
+
+// removeMapping removes the mapping for the short URL of m from the source of
+// m.  If no such mapping exists, ErrNotFound is returned.
+func (s *Server) removeMapping(m Mapping) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if m.Source.Kind == SourceManual {
+		if err := s.unpersist(m.Host, m.ShortPath); err != nil {
+			return err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.urls.delete(m) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// unpersist removes the mapping for the short URL with the specified host and
+// path from the server's store.  The caller must hold s.writeMutex, and not
+// s.mutex.
+func (s *Server) unpersist(host, shortPath string) error {
+	if s.store == nil {
+		return nil
+	}
+	if err := s.store.Delete(host, shortPath); err != nil {
+		return fmt.Errorf("gum: error deleting stored mapping %v: %w", Mapping{Host: host, ShortPath: shortPath}.key(), err)
+	}
+	return nil
+}
+
+// SetStore sets the store used to persist manual mappings and tombstones, and
+// adds the mappings it contains to the server.  Stored mappings are merged
+// with those provided by handlers according to the usual precedence rules.
+// Subsequent changes to manual mappings, such as those made with AddMapping or
+// the admin API, and new tombstones are written to the store.  Mappings which
+// were added before the store was set are not written to it.
+func (s *Server) SetStore(st Store) error {
+	mappings, err := st.Load()
+	if err != nil {
+		return fmt.Errorf("gum: error loading stored mappings: %w", err)
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.mutex.Lock()
+	var expired []Mapping
+	for _, m := range mappings {
+		if err := m.validate(); err != nil {
+			log.Printf("Skipping invalid stored mapping %v: %v", m.key(), err)
+			continue
+		}
+		if !m.isTombstone() {
+			m.Source.Kind = SourceManual
+		}
+		if s.expired(m) {
+			expired = append(expired, m)
+			continue
+		}
+		s.urls.set(m)
+	}
+	s.store = st
+	s.mutex.Unlock()
+
+	for _, m := range expired {
+		if err := st.Delete(m.Host, m.ShortPath); err != nil {
+			log.Printf("error deleting expired tombstone %v: %v", m.key(), err)
+		}
+	}
+	return nil
+}
+
+// get returns the mapping used to serve requests for the short URL with the
+// specified key, without falling back to mappings for all hosts.
+func (s *Server) get(key string) (Mapping, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.urls.get(key)
+}
+
+// Lookup returns the mapping used to serve requests for the short URL with
+// the specified host and path.  Mappings for host take precedence over those
+// without a host.
+func (s *Server) Lookup(host, shortPath string) (Mapping, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	host = stripPort(strings.ToLower(host))
+	for _, key := range []string{host + shortPath, shortPath} {
+		if m, ok := s.urls.get(key); ok && !s.expired(m) {
+			return m, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// Mappings returns a snapshot of the mappings used to serve each short URL,
+// sorted by host and short path.
+func (s *Server) Mappings() []Mapping {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.urls.mappings()
+}
+
+// ShortURLs returns the mappings of the short URLs which redirect to
+// permalink, sorted by host and short path.  Permalinks are matched ignoring
+// the case of their scheme and host and any fragment.  Retired short URLs are
+// not included.
+func (s *Server) ShortURLs(permalink string) []Mapping {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.urls.permalink(permalink)
+}
+
+// Conflicts returns the short URLs which are mapped to different permalinks by
+// different sources, sorted by host and short path.
+func (s *Server) Conflicts() []Conflict {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.urls.conflicts()
 }
 
 // AddHandler adds the provided Handler to the server.
 func (s *Server) AddHandler(h Handler) error {
-	if err := h.Register(s.mux); err != nil {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	s.mutex.RLock()
+	mux, mappings := s.mux, s.mappings
+	s.mutex.RUnlock()
+
+	if err := addHandler(h, mux, mappings); err != nil {
+		closeHandlers([]Handler{h})
+		return err
+	}
+
+	s.mutex.Lock()
+	s.handlers = append(s.handlers, h)
+	s.mutex.Unlock()
+	return nil
+}
+
+// SetReady marks s as ready to serve requests.  Reload does so once all of
+// its handlers have been loaded, but servers whose handlers are added one at
+// a time with AddHandler should call SetReady after adding the last one.
+func (s *Server) SetReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ready = true
+}
+
+// Handlers returns the handlers which have been added to the server.
+func (s *Server) Handlers() []Handler {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]Handler(nil), s.handlers...)
+}
+
+// RemoveHandler removes h from the server, closing it if it implements
+// io.Closer.  Any mappings previously provided by h are not removed.  If h
+// has not been added to the server, ErrNotFound is returned.
+func (s *Server) RemoveHandler(h Handler) error {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	s.mutex.RLock()
+	var handlers []Handler
+	for _, sh := range s.handlers {
+		if sh != h {
+			handlers = append(handlers, sh)
+		}
+	}
+	s.mutex.RUnlock()
+	if len(handlers) == len(s.handlers) {
+		return ErrNotFound
+	}
+
+	// ServeMux does not support removing patterns, so register the
+	// remaining handlers with a new one.
+	mux := http.NewServeMux()
+	for _, sh := range handlers {
+		if err := register(sh, mux); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	s.mux, s.handlers = mux, handlers
+	s.mutex.Unlock()
+
+	closeHandlers([]Handler{h})
+	return nil
+}
+
+// Reload replaces all of the server's handlers, and the mappings they
+// provided, with the provided handlers.  Mappings added with AddMapping are
+// retained, as are tombstones.  The new handlers are fully loaded before being
+// swapped in, so requests continue to be served by the previous handlers in
+// the meantime.  Previous handlers which implement io.Closer are closed once
+// they have been replaced.  If any of the new handlers returns an error, the
+// server continues to use its previous handlers.
+func (s *Server) Reload(handlers ...Handler) error {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	start := time.Now()
+	mux := http.NewServeMux()
+	urls := newTable()
+	mappings := make(chan Mapping)
+	s.readers.Add(1)
+	go s.readMappings(mappings, urls)
+
+	for _, h := range handlers {
+		if err := addHandler(h, mux, mappings); err != nil {
+			closeHandlers(handlers)
+			close(mappings)
+			metrics.reloadErrors.inc()
+			return err
+		}
+	}
+	metrics.reloads.observe(time.Since(start))
+
+	// writeMutex ensures that no mapping written to the store is missing
+	// from the copied table.
+	s.writeMutex.Lock()
+	s.mutex.Lock()
+	for _, m := range append(s.urls.kind(SourceManual), s.urls.kind(SourceTombstone)...) {
+		urls.set(m)
+	}
+	oldHandlers, oldMappings := s.handlers, s.mappings
+	s.mux, s.urls, s.mappings, s.handlers = mux, urls, mappings, handlers
+	s.ready = true
+	s.mutex.Unlock()
+	s.writeMutex.Unlock()
+
+	// handlers must be closed before their mappings channel, since they
+	// may still be writing to it.
+	closeHandlers(oldHandlers)
+	close(oldMappings)
+	return nil
+}
+
+// Close closes all of the server's handlers which implement io.Closer and
+// stops reading new mappings.  The server continues to serve requests using
+// its existing mappings, but no further handlers can be added.
+func (s *Server) Close() error {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.mutex.Lock()
+	handlers, mappings := s.handlers, s.mappings
+	s.ready = false
+	s.mutex.Unlock()
+
+	closeHandlers(handlers)
+	close(mappings)
+	s.readers.Wait()
+	return nil
+}
+
+// Shutdown closes the server as with Close, but returns the context's error
+// if the context expires before the close completes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- s.Close() }()
+
+	select {
+	case err := <-done:
 		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if err := h.Mappings(s.mappings); err != nil {
+}
+
+// addHandler registers h with mux and provides it the mappings channel.  It
+// returns once all mappings written by h so far have been applied.
+func addHandler(h Handler, mux *http.ServeMux, mappings chan<- Mapping) error {
+	if err := register(h, mux); err != nil {
 		return err
 	}
+	if err := h.Mappings(mappings); err != nil {
+		return err
+	}
+	// mappings are applied in order, so once a zero mapping has been
+	// received, all previous mappings have been applied.
+	mappings <- Mapping{}
 	return nil
 }
 
+// register registers h with mux, returning an error rather than panicking if
+// h registers a pattern that is already registered.
+func register(h Handler, mux *http.ServeMux) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("gum: error registering handler: %v", r)
+		}
+	}()
+	return h.Register(mux)
+}
+
+// closeHandlers closes each of handlers that implements io.Closer.
+func closeHandlers(handlers []Handler) {
+	for _, h := range handlers {
+		if c, ok := h.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("error closing handler: %v", err)
+			}
+		}
+	}
+}
+
 // Mapping represents a mapping between a short URL path and the permalink URL it is for.
 type Mapping struct {
+	// Host is the optional host of the short URL.  If empty, the mapping
+	// applies to requests for any host that does not have its own mapping
+	// for ShortPath.
+	Host string `json:"host,omitempty"`
+
 	// ShortPath is the path of the short URL (including leading slash) to
 	// be mapped.
-	ShortPath string
+	ShortPath string `json:"short_path"`
+
+	// Permalink is the destination URL being mapped to.  Permalink may be
+	// empty if Status is 410 (Gone).
+	Permalink string `json:"permalink"`
+
+	// Status is the HTTP status to return in redirect responses, or 410
+	// (Gone) for short URLs which have been retired.  If zero, 301 (Moved
+	// Permanently) is used.
+	Status int `json:"status,omitempty"`
+
+	// Source identifies where the mapping came from, and determines which
+	// mapping is used when multiple sources map the same short URL.
+	Source Source `json:"source"`
+
+	// Query is the policy for handling the query string of requests.  By
+	// default, the request query is dropped.
+	Query QueryPolicy `json:"query,omitempty"`
+
+	// Title is the title of the permalink's page, if known, which is shown
+	// when the short URL is previewed.
+	Title string `json:"title,omitempty"`
+}
+
+// validate returns an error if m is not a valid mapping.
+func (m Mapping) validate() error {
+	if !strings.HasPrefix(m.ShortPath, "/") || len(m.ShortPath) < 2 {
+		return fmt.Errorf("gum: short path %q should be a non-root path with a leading slash", m.ShortPath)
+	}
+	if strings.Contains(m.Host, "/") {
+		return fmt.Errorf("gum: invalid host %q", m.Host)
+	}
+	if m.Status != 0 && m.Status != http.StatusGone && !isRedirectStatus(m.Status) {
+		return fmt.Errorf("gum: status %d is not a valid redirect status", m.Status)
+	}
+	if m.Permalink == "" && m.Status != http.StatusGone {
+		return errors.New("gum: permalink must not be empty")
+	}
+	if _, err := url.Parse(m.Permalink); err != nil {
+		return fmt.Errorf("gum: invalid permalink %q: %w", m.Permalink, err)
+	}
+	return m.Query.Validate()
+}
+
+// status returns the HTTP status used to serve requests for m.
+func (m Mapping) status() int {
+	if m.Status == 0 {
+		return http.StatusMovedPermanently
+	}
+	return m.Status
+}
+
+// redirects reports whether m redirects to its permalink, rather than being
+// retired.
+func (m Mapping) redirects() bool {
+	return m.status() != http.StatusGone && m.Permalink != ""
+}
+
+// sameTarget reports whether m and n redirect to the same permalink with the
+// same status.
+func (m Mapping) sameTarget(n Mapping) bool {
+	return m.Permalink == n.Permalink && m.status() == n.status()
+}
+
+// isDeletion reports whether m is a deletion sent on a mappings channel, which
+// has neither a permalink nor a status.
+func (m Mapping) isDeletion() bool {
+	return m.Permalink == "" && m.Status == 0
+}
+
+// isTombstone reports whether m is a tombstone for a retired short URL.
+func (m Mapping) isTombstone() bool {
+	return m.Source.Kind == SourceTombstone
+}
+
+// tombstone returns a tombstone recording that the short URL of m was retired
+// at t.  The tombstone retains the permalink of m for reference.
+func (m Mapping) tombstone(t time.Time) Mapping {
+	return Mapping{
+		Host:      m.Host,
+		ShortPath: m.ShortPath,
+		Permalink: m.Permalink,
+		Status:    http.StatusGone,
+		Source:    Source{Kind: SourceTombstone, Name: m.Source.Name, File: m.Source.File, Modified: t},
+		Title:     m.Title,
+	}
+}
 
-	// Permalink is the destination URL being mapped to.
-	Permalink string
+// key returns the key used to identify m in the Server's table of mappings.
+func (m Mapping) key() string {
+	return strings.ToLower(m.Host) + m.ShortPath
 }
 
 // A Handler serves requests for short URLs.  Typically, a handler will
@@ -122,6 +853,8 @@ type Handler interface {
 
 	// Mappings provides a write only channel for the handler to write
 	// static Mapping values onto.  These mappings are then registered with
-	// and the redirects handled by the Server.
+	// and the redirects handled by the Server.  Handlers which continue to
+	// write mappings after this method returns should implement io.Closer,
+	// and must stop writing mappings once closed.
 	Mappings(chan<- Mapping) error
 }
@@ -7,8 +7,11 @@
 package gum
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -35,8 +38,8 @@ func TestMappings(t *testing.T) {
 		if m != nil {
 			m.ShortPath = "/foo"
 			g.mappings <- *m
-			// sleep long enough for mapping to be processed
-			time.Sleep(3 * time.Millisecond)
+			// wait for mapping to be processed
+			g.mappings <- Mapping{}
 		}
 
 		resp, err := tr.RoundTrip(req)
@@ -62,3 +65,451 @@ func TestMappings(t *testing.T) {
 
 	}
 }
+
+func TestServer_AddMapping(t *testing.T) {
+	g := NewServer()
+
+	m := Mapping{ShortPath: "/a", Permalink: "/p", Source: Source{Kind: SourceManual}}
+	if err := g.AddMapping(m); err != nil {
+		t.Fatalf("AddMapping(%v) returned error: %v", m, err)
+	}
+	// adding the same mapping again is not a conflict
+	if err := g.AddMapping(m); err != nil {
+		t.Errorf("AddMapping(%v) again returned error: %v", m, err)
+	}
+
+	conflict := Mapping{ShortPath: "/a", Permalink: "/q", Source: Source{Kind: SourceManual}}
+	err := g.AddMapping(conflict)
+	var cerr *ConflictError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("AddMapping(%v) returned error %v, want ConflictError", conflict, err)
+	}
+	if got, want := *cerr, (ConflictError{Existing: m, Mapping: conflict}); got != want {
+		t.Errorf("AddMapping(%v) returned conflict %v, want %v", conflict, got, want)
+	}
+
+	// the same short path on another host does not conflict
+	// mappings without a source are manual mappings
+	other := Mapping{Host: "x.example", ShortPath: "/a", Permalink: "/q"}
+	if err := g.AddMapping(other); err != nil {
+		t.Errorf("AddMapping(%v) returned error: %v", other, err)
+	}
+	other.Source.Kind = SourceManual
+
+	for _, m := range []Mapping{
+		{ShortPath: "", Permalink: "/p"},
+		{ShortPath: "/", Permalink: "/p"},
+		{ShortPath: "a", Permalink: "/p"},
+		{ShortPath: "/a", Permalink: ""},
+		{Host: "x/y", ShortPath: "/a", Permalink: "/p"},
+		{ShortPath: "/b", Permalink: "/p", Status: 200},
+		{ShortPath: "/b", Permalink: "", Status: 302},
+		{ShortPath: "/a", Permalink: "/p", Status: 302}, // conflicting status
+	} {
+		if err := g.AddMapping(m); err == nil {
+			t.Errorf("AddMapping(%v) did not return expected error", m)
+		}
+	}
+
+	if got, want := g.Mappings(), []Mapping{m, other}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Mappings() returned %v, want %v", got, want)
+	}
+
+	if got, ok := g.Lookup("x.example:80", "/a"); !ok || got != other {
+		t.Errorf("Lookup(x.example:80, /a) returned %v, %t, want %v, true", got, ok, other)
+	}
+
+	if err := g.RemoveMapping("", "/a"); err != nil {
+		t.Errorf("RemoveMapping returned error: %v", err)
+	}
+	if err := g.RemoveMapping("", "/a"); err != ErrNotFound {
+		t.Errorf("RemoveMapping of removed mapping returned error %v, want %v", err, ErrNotFound)
+	}
+	if got, ok := g.Lookup("", "/a"); ok {
+		t.Errorf("Lookup of removed mapping returned %v", got)
+	}
+}
+
+func TestMappings_Host(t *testing.T) {
+	g := NewServer()
+
+	mappings := []Mapping{
+		{ShortPath: "/t1", Permalink: "/any"},
+		{Host: "a.example", ShortPath: "/t1", Permalink: "/a"},
+		{Host: "b.example", ShortPath: "/t1", Permalink: "/b"},
+	}
+	for _, m := range mappings {
+		if err := g.AddMapping(m); err != nil {
+			t.Fatalf("AddMapping(%v) returned error: %v", m, err)
+		}
+	}
+
+	tests := []struct {
+		host, location string
+	}{
+		{"a.example", "/a"},
+		{"A.example:8080", "/a"},
+		{"b.example", "/b"},
+		{"c.example", "/any"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "http://"+tt.host+"/t1", nil)
+		if err != nil {
+			t.Fatalf("error constructing request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		g.ServeHTTP(resp, req)
+
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("GET %v/t1 returned Location header %q, want %q", tt.host, got, want)
+		}
+	}
+}
+
+// testHandler is a Handler which provides a fixed list of mappings and
+// records whether it has been closed.
+type testHandler struct {
+	prefix   string
+	mappings []Mapping
+	err      error // returned by Mappings
+	closed   bool
+}
+
+func (h *testHandler) Register(mux *http.ServeMux) error {
+	if h.prefix != "" {
+		mux.Handle(h.prefix, http.NotFoundHandler())
+	}
+	return nil
+}
+
+func (h *testHandler) Mappings(mappings chan<- Mapping) error {
+	for _, m := range h.mappings {
+		mappings <- m
+	}
+	return h.err
+}
+
+func (h *testHandler) Close() error {
+	h.closed = true
+	return nil
+}
+
+func TestServer_AddHandler_Error(t *testing.T) {
+	g := NewServer()
+	h := &testHandler{err: errors.New("bad handler")}
+	if err := g.AddHandler(h); err != h.err {
+		t.Errorf("AddHandler returned error %v, want %v", err, h.err)
+	}
+	if !h.closed {
+		t.Errorf("AddHandler did not close handler which failed to load")
+	}
+	if got := g.Handlers(); len(got) != 0 {
+		t.Errorf("Handlers returned %v, want none", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	g := NewServer()
+
+	old := &testHandler{prefix: "/x", mappings: []Mapping{{ShortPath: "/a", Permalink: "/old"}}}
+	if err := g.AddHandler(old); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	manual := Mapping{ShortPath: "/m", Permalink: "/manual", Source: Source{Kind: SourceManual}}
+	if err := g.AddMapping(manual); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+
+	// reloading with the same mux pattern should not conflict
+	new := &testHandler{prefix: "/x", mappings: []Mapping{{ShortPath: "/b", Permalink: "/new"}}}
+	if err := g.Reload(new); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if !old.closed {
+		t.Errorf("Reload did not close previous handler")
+	}
+	if new.closed {
+		t.Errorf("Reload closed new handler")
+	}
+
+	tests := []struct {
+		path, location string
+	}{
+		{"/a", ""},
+		{"/b", "/new"},
+		{"/m", "/manual"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatalf("error constructing request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		g.ServeHTTP(resp, req)
+
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("GET %v returned Location header %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	g := NewServer()
+
+	h := &testHandler{mappings: []Mapping{{ShortPath: "/a", Permalink: "/p"}}}
+	if err := g.AddHandler(h); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := g.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	if !h.closed {
+		t.Errorf("Shutdown did not close handler")
+	}
+
+	if err := g.AddHandler(&testHandler{}); err != ErrServerClosed {
+		t.Errorf("AddHandler after Shutdown returned error %v, want %v", err, ErrServerClosed)
+	}
+	if err := g.Reload(); err != ErrServerClosed {
+		t.Errorf("Reload after Shutdown returned error %v, want %v", err, ErrServerClosed)
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("second Close returned error: %v", err)
+	}
+}
+
+func TestServer_Conflicts(t *testing.T) {
+	g := NewServer()
+
+	now := time.Now()
+	older := Mapping{ShortPath: "/a", Permalink: "/older", Source: Source{Kind: SourceStatic, File: "older.html", Modified: now.Add(-time.Hour)}}
+	newer := Mapping{ShortPath: "/a", Permalink: "/newer", Source: Source{Kind: SourceStatic, File: "newer.html", Modified: now}}
+	same := Mapping{ShortPath: "/a", Permalink: "/newer", Source: Source{Kind: SourceStatic, File: "same.html", Modified: now}}
+	config := Mapping{ShortPath: "/a", Permalink: "/config", Source: Source{Kind: SourceConfig}}
+
+	h := &testHandler{mappings: []Mapping{older, newer, same}}
+	if err := g.AddHandler(h); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	// newest static file wins, and files with the same permalink are not
+	// reported as conflicting
+	if got, _ := g.Lookup("", "/a"); got != newer {
+		t.Errorf("Lookup returned %v, want %v", got, newer)
+	}
+	want := []Conflict{{Mapping: newer, Overridden: []Mapping{older}}}
+	if got := g.Conflicts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Conflicts returned %v, want %v", got, want)
+	}
+
+	// config mappings take precedence over static files
+	g.mappings <- config
+	g.mappings <- Mapping{}
+	if got, _ := g.Lookup("", "/a"); got != config {
+		t.Errorf("Lookup returned %v, want %v", got, config)
+	}
+
+	// deleting a mapping falls back to the next source
+	g.mappings <- Mapping{ShortPath: "/a", Source: config.Source}
+	g.mappings <- Mapping{ShortPath: "/a", Source: newer.Source}
+	g.mappings <- Mapping{}
+	if got, _ := g.Lookup("", "/a"); got != same {
+		t.Errorf("Lookup returned %v, want %v", got, same)
+	}
+}
+
+func TestServer_Status(t *testing.T) {
+	g := NewServer()
+	for _, m := range []Mapping{
+		{ShortPath: "/a", Permalink: "/pa"},
+		{ShortPath: "/b", Permalink: "/pb", Status: http.StatusFound},
+		{ShortPath: "/c", Permalink: "/pc", Status: http.StatusPermanentRedirect},
+		{ShortPath: "/d", Status: http.StatusGone},
+		{ShortPath: "/e", Permalink: "/pe", Status: http.StatusGone},
+	} {
+		if err := g.AddMapping(m); err != nil {
+			t.Fatalf("AddMapping(%v) returned error: %v", m, err)
+		}
+	}
+
+	tests := []struct {
+		in, location string
+		code         int
+	}{
+		{"/a", "/pa", http.StatusMovedPermanently},
+		{"/b", "/pb", http.StatusFound},
+		{"/c", "/pc", http.StatusPermanentRedirect},
+		{"/d", "", http.StatusGone},
+		{"/e", "", http.StatusGone},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		g.ServeHTTP(resp, httptest.NewRequest("GET", tt.in, nil))
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("request for %q returned status %v, want %v", tt.in, got, want)
+		}
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("request for %q redirected to %q, want %q", tt.in, got, want)
+		}
+	}
+}
+
+func TestServer_Tombstones(t *testing.T) {
+	g := NewServer()
+	g.SetGonePage([]byte("<p>gone</p>"))
+	g.SetTombstoneRetention(time.Hour)
+
+	now := time.Now()
+	static := Mapping{ShortPath: "/a", Permalink: "/pa", Source: Source{Kind: SourceStatic, File: "a.html"}}
+	g.mappings <- static
+	g.mappings <- static.tombstone(now)
+	g.mappings <- Mapping{ShortPath: "/a", Source: static.Source}
+	old := Mapping{ShortPath: "/b", Permalink: "/pb", Source: Source{Kind: SourceStatic}}
+	g.mappings <- old.tombstone(now.Add(-2 * time.Hour))
+	g.mappings <- Mapping{}
+
+	// tombstones are served with the gone page until they expire
+	resp := httptest.NewRecorder()
+	g.ServeHTTP(resp, httptest.NewRequest("GET", "/a", nil))
+	if got, want := resp.Code, http.StatusGone; got != want {
+		t.Errorf("request for /a returned status %v, want %v", got, want)
+	}
+	if got, want := resp.Body.String(), "<p>gone</p>"; got != want {
+		t.Errorf("request for /a returned body %q, want %q", got, want)
+	}
+	if _, ok := g.Lookup("", "/b"); ok {
+		t.Errorf("Lookup(/b) returned expired tombstone")
+	}
+
+	// tombstones are overridden by any other source, and are not conflicts
+	g.mappings <- Mapping{ShortPath: "/a", Permalink: "/new", Source: Source{Kind: SourceStatic, File: "new.html"}}
+	g.mappings <- Mapping{}
+	if m, _ := g.Lookup("", "/a"); m.Permalink != "/new" {
+		t.Errorf("Lookup(/a) returned %v, want mapping to /new", m)
+	}
+	if got := g.Conflicts(); len(got) != 0 {
+		t.Errorf("Conflicts returned %v, want none", got)
+	}
+
+	// tombstones are kept on reload
+	if err := g.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if m, _ := g.Lookup("", "/a"); m != static.tombstone(now) {
+		t.Errorf("Lookup(/a) after reload returned %v, want tombstone", m)
+	}
+
+	if got, want := g.ExpireTombstones(), 1; got != want {
+		t.Errorf("ExpireTombstones removed %d tombstones, want %d", got, want)
+	}
+	if got := g.Mappings(); len(got) != 1 || got[0].ShortPath != "/a" {
+		t.Errorf("Mappings after expiring tombstones returned %v, want only /a", got)
+	}
+}
+
+func TestServer_Tombstones_UnknownSource(t *testing.T) {
+	g := NewServer()
+	g.SetTombstoneRetention(time.Hour)
+
+	// handlers which do not set a source can map a retired short URL again
+	retired := Mapping{ShortPath: "/x", Permalink: "/old", Source: Source{Kind: SourceStatic}}
+	g.mappings <- retired.tombstone(time.Now())
+	g.mappings <- Mapping{ShortPath: "/x", Permalink: "/new"}
+	g.mappings <- Mapping{}
+
+	resp := httptest.NewRecorder()
+	g.ServeHTTP(resp, httptest.NewRequest("GET", "/x", nil))
+	if got, want := resp.Code, http.StatusMovedPermanently; got != want {
+		t.Errorf("request for /x returned status %v, want %v", got, want)
+	}
+	if got, want := resp.Header().Get("Location"), "/new"; got != want {
+		t.Errorf("request for /x redirected to %q, want %q", got, want)
+	}
+
+	// tombstones, including expired ones not yet removed, do not conflict
+	// with new manual mappings
+	g.mappings <- Mapping{ShortPath: "/y", Permalink: "/old", Source: Source{Kind: SourceStatic}}.tombstone(time.Now())
+	g.mappings <- Mapping{ShortPath: "/z", Permalink: "/old", Source: Source{Kind: SourceStatic}}.tombstone(time.Now().Add(-2 * time.Hour))
+	g.mappings <- Mapping{}
+	for _, path := range []string{"/y", "/z"} {
+		if err := g.AddMapping(Mapping{ShortPath: path, Permalink: "/manual"}); err != nil {
+			t.Errorf("AddMapping(%v) returned error: %v", path, err)
+		}
+		if m, _ := g.Lookup("", path); m.Permalink != "/manual" {
+			t.Errorf("Lookup(%v) returned %v, want mapping to /manual", path, m)
+		}
+	}
+}
+
+func TestServer_ShortURLs(t *testing.T) {
+	g := NewServer()
+
+	// shortURLs returns the short paths redirecting to permalink.
+	shortURLs := func(permalink string) []string {
+		var paths []string
+		for _, m := range g.ShortURLs(permalink) {
+			paths = append(paths, m.Host+m.ShortPath)
+		}
+		return paths
+	}
+
+	static := Source{Kind: SourceStatic, File: "post.html"}
+	h := &testHandler{mappings: []Mapping{
+		{ShortPath: "/p", Permalink: "https://example.com/post", Source: static},
+		{ShortPath: "/p2", Permalink: "https://example.com/post", Source: static},
+		{Host: "s.example", ShortPath: "/p", Permalink: "https://example.com/post", Source: static},
+		{ShortPath: "/o", Permalink: "https://example.com/other", Source: static},
+	}}
+	if err := g.AddHandler(h); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	want := []string{"/p", "/p2", "s.example/p"}
+	if got := shortURLs("https://example.com/post"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortURLs returned %v, want %v", got, want)
+	}
+	if got := shortURLs("HTTPS://Example.com/post#comments"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortURLs with fragment returned %v, want %v", got, want)
+	}
+	if got := shortURLs("https://example.com/POST"); got != nil {
+		t.Errorf("ShortURLs with different path returned %v, want none", got)
+	}
+
+	// only the mapping served for a short URL is indexed
+	if err := g.SetMapping(Mapping{ShortPath: "/p2", Permalink: "https://example.com/other"}); err != nil {
+		t.Fatalf("SetMapping returned error: %v", err)
+	}
+	if got, want := shortURLs("https://example.com/other"), []string{"/o", "/p2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortURLs(other) returned %v, want %v", got, want)
+	}
+	if err := g.RemoveMapping("", "/p2"); err != nil {
+		t.Fatalf("RemoveMapping returned error: %v", err)
+	}
+	if got, want := shortURLs("https://example.com/other"), []string{"/o"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortURLs(other) after removal returned %v, want %v", got, want)
+	}
+
+	// retired short URLs are not included
+	g.mappings <- Mapping{ShortPath: "/p", Permalink: "https://example.com/post", Status: http.StatusGone, Source: static}
+	g.mappings <- Mapping{ShortPath: "/o", Source: static}
+	g.mappings <- Mapping{}
+	if got, want := shortURLs("https://example.com/post"), []string{"s.example/p"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShortURLs after retiring /p returned %v, want %v", got, want)
+	}
+	if got := shortURLs("https://example.com/other"); got != nil {
+		t.Errorf("ShortURLs(other) after deletion returned %v, want none", got)
+	}
+
+	// the index is rebuilt on reload
+	if err := g.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if got := shortURLs("https://example.com/post"); got != nil {
+		t.Errorf("ShortURLs after reload returned %v, want none", got)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A HealthChecker is a Handler which can report problems that prevent it from
+// serving up to date mappings, such as a StaticHandler whose file watcher has
+// failed.
+type HealthChecker interface {
+	// Healthy returns an error describing why the handler is unhealthy,
+	// or nil if it is healthy.
+	Healthy() error
+}
+
+// Ready returns nil if s is ready to serve requests, which is once the initial
+// mappings of all of its handlers have been loaded with Reload, or SetReady
+// has been called.
+// Otherwise, it returns an error describing why s is not ready, including any
+// errors reported by handlers which implement HealthChecker.
+func (s *Server) Ready() error {
+	s.mutex.RLock()
+	ready, handlers := s.ready, s.handlers
+	s.mutex.RUnlock()
+
+	if !ready {
+		return errors.New("gum: handlers have not been loaded")
+	}
+	var errs []string
+	for _, h := range handlers {
+		if hc, ok := h.(HealthChecker); ok {
+			if err := hc.Healthy(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("gum: unhealthy handlers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// HealthHandler serves liveness and readiness checks for a Server, such as for
+// a load balancer or Kubernetes probes.  The liveness check always responds
+// with a 200 (OK) status while the process is serving requests.  The readiness
+// check responds with a 200 (OK) status if the server is ready (see
+// Server.Ready), and 503 (Service Unavailable) with the reason otherwise.
+type HealthHandler struct {
+	// LivenessPath is the path of the liveness check.  If empty,
+	// "/healthz" is used.
+	LivenessPath string
+
+	// ReadinessPath is the path of the readiness check.  If empty,
+	// "/readyz" is used.
+	ReadinessPath string
+
+	server *Server
+	next   http.Handler
+}
+
+// NewHealthHandler constructs a new HealthHandler for s.  Requests for other
+// paths are served by next, such as the Server itself, or respond with a 404
+// (Not Found) if next is nil.
+func NewHealthHandler(s *Server, next http.Handler) *HealthHandler {
+	return &HealthHandler{server: s, next: next}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case pathOrDefault(h.LivenessPath, "/healthz"):
+		writeHealth(w, nil)
+	case pathOrDefault(h.ReadinessPath, "/readyz"):
+		writeHealth(w, h.server.Ready())
+	default:
+		if h.next == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.next.ServeHTTP(w, r)
+	}
+}
+
+// pathOrDefault returns path, or def if path is empty.
+func pathOrDefault(path, def string) string {
+	if path == "" {
+		return def
+	}
+	return path
+}
+
+// writeHealth writes the plain text response to a health check which failed
+// with err, if not nil.
+func writeHealth(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
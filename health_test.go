@@ -0,0 +1,160 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// unhealthyHandler is a Handler which reports a fixed health error.
+type unhealthyHandler struct {
+	testHandler
+	err error
+}
+
+func (h *unhealthyHandler) Healthy() error { return h.err }
+
+func TestHealthHandler(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	h := NewHealthHandler(g, g)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// not ready until handlers are loaded
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("GET /healthz returned status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz before loading returned status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	unhealthy := &unhealthyHandler{}
+	if err := g.Reload(unhealthy); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if w := get("/readyz"); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("GET /readyz returned %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, "ok\n")
+	}
+
+	unhealthy.err = errors.New("watcher failed")
+	w := get("/readyz")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "watcher failed") {
+		t.Errorf("GET /readyz with unhealthy handler returned %d %q, want %d with error", w.Code, w.Body.String(), http.StatusServiceUnavailable)
+	}
+	if w := get("/healthz"); w.Code != http.StatusOK {
+		t.Errorf("GET /healthz with unhealthy handler returned status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// other requests are served by the server
+	if w := get("/a"); w.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /a returned status %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+
+	// replacing the unhealthy handler makes the server ready again
+	if err := g.Reload(&testHandler{}); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if w := get("/readyz"); w.Code != http.StatusOK {
+		t.Errorf("GET /readyz after reload returned status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	g.Close()
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz after close returned status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// blockingHandler is a Handler whose Mappings blocks until release is closed.
+type blockingHandler struct {
+	testHandler
+	release chan struct{}
+}
+
+func (h *blockingHandler) Mappings(mappings chan<- Mapping) error {
+	<-h.release
+	return h.testHandler.Mappings(mappings)
+}
+
+func TestServer_Ready(t *testing.T) {
+	// not ready until all handlers passed to Reload are loaded
+	g := NewServer()
+	second := &blockingHandler{release: make(chan struct{})}
+	errc := make(chan error)
+	go func() { errc <- g.Reload(&testHandler{}, second) }()
+	if err := g.Ready(); err == nil {
+		t.Errorf("Ready returned nil while loading the second handler")
+	}
+	close(second.release)
+	if err := <-errc; err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if err := g.Ready(); err != nil {
+		t.Errorf("Ready returned error after Reload: %v", err)
+	}
+
+	// adding handlers individually requires SetReady
+	g = NewServer()
+	for _, h := range []Handler{&testHandler{}, &testHandler{}} {
+		if err := g.AddHandler(h); err != nil {
+			t.Fatalf("AddHandler returned error: %v", err)
+		}
+		if err := g.Ready(); err == nil {
+			t.Errorf("Ready returned nil after AddHandler")
+		}
+	}
+	g.SetReady()
+	if err := g.Ready(); err != nil {
+		t.Errorf("Ready returned error after SetReady: %v", err)
+	}
+}
+
+func TestHealthHandler_Paths(t *testing.T) {
+	g := NewServer()
+	g.Reload()
+	h := NewHealthHandler(g, nil)
+	h.LivenessPath, h.ReadinessPath = "/-/live", "/-/ready"
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/-/live", http.StatusOK},
+		{"/-/ready", http.StatusOK},
+		{"/healthz", http.StatusNotFound},
+		{"/readyz", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s returned status %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+}
+
+func TestStaticHandler_Healthy(t *testing.T) {
+	h, err := NewStaticHandler(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStaticHandler returned error: %v", err)
+	}
+	if err := h.Healthy(); err != nil {
+		t.Errorf("Healthy returned error: %v", err)
+	}
+	h.watchErr = errors.New("queue overflow")
+	if err := h.Healthy(); err == nil || !strings.Contains(err.Error(), "queue overflow") {
+		t.Errorf("Healthy returned %v, want watcher error", err)
+	}
+}
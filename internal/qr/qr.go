@@ -0,0 +1,594 @@
+// Copyright (c) Project Nayuki. (MIT License)
+// https://www.nayuki.io/page/qr-code-generator-library
+//
+// This file is adapted from the QR Code generator library by Project Nayuki.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file in this directory.
+
+// Package qr encodes data as QR codes, as specified by ISO/IEC 18004.  Data
+// is always encoded in byte mode, which is well suited to URLs, using the
+// smallest version (size) of QR code that fits the data at the requested
+// error correction level.
+//
+// The encoder is adapted from the QR Code generator library by Project Nayuki,
+// and is distributed under the MIT License.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Level is an error correction level, which determines how much of a QR
+// code can be damaged or obscured while remaining readable.
+type Level int
+
+// Error correction levels, in increasing order of redundancy.
+const (
+	L Level = iota // recovers about 7% of the code
+	M              // recovers about 15% of the code
+	Q              // recovers about 25% of the code
+	H              // recovers about 30% of the code
+)
+
+// ParseLevel parses an error correction level, one of "L", "M", "Q", or "H".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return L, nil
+	case "M":
+		return M, nil
+	case "Q":
+		return Q, nil
+	case "H":
+		return H, nil
+	}
+	return 0, fmt.Errorf("qr: unknown error correction level %q", s)
+}
+
+func (l Level) String() string {
+	if l < L || l > H {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return "LMQH"[l : l+1]
+}
+
+// formatBits returns the two bit value identifying l in format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// ErrTooLong is returned when data is too long to fit in a QR code at the
+// requested error correction level.
+var ErrTooLong = errors.New("qr: data too long")
+
+// A Code is an encoded QR code.
+type Code struct {
+	// Size is the width and height of the code in modules, not including
+	// the quiet zone around it.
+	Size int
+
+	// Version is the version of the code, from 1 to 40.
+	Version int
+
+	// Level is the error correction level of the code.
+	Level Level
+
+	// Mask is the mask pattern applied to the code, from 0 to 7.
+	Mask int
+
+	// modules are the modules of the code in row-major order, true for
+	// dark modules.
+	modules []bool
+	// function marks modules which are part of function patterns rather
+	// than data, only used while encoding.
+	function []bool
+}
+
+// Black reports whether the module at column x and row y is dark.  Modules
+// outside the code, such as those in the quiet zone, are light.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// Encode encodes data as a QR code with the specified error correction level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, fmt.Errorf("qr: invalid error correction level %d", int(level))
+	}
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if dataBits(v, len(data)) <= 8*numDataCodewords(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	// byte mode segment, followed by a terminator and padding
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := 8 * numDataCodewords(version, level)
+	if n := capacity - len(bb); n < 4 {
+		bb.append(0, n)
+	} else {
+		bb.append(0, 4)
+	}
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	c := &Code{
+		Size:     4*version + 17,
+		Version:  version,
+		Level:    level,
+		modules:  make([]bool, (4*version+17)*(4*version+17)),
+		function: make([]bool, (4*version+17)*(4*version+17)),
+	}
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(bb.bytes()))
+
+	// choose the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // masks are undone by applying them again
+	}
+	c.Mask = best
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	c.function = nil
+	return c, nil
+}
+
+// dataBits returns the number of bits needed to encode n bytes in a code of
+// the specified version.
+func dataBits(version, n int) int {
+	return 4 + countBits(version) + 8*n
+}
+
+// countBits returns the number of bits of the character count of a byte mode
+// segment in a code of the specified version.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// eccCodewordsPerBlock and numBlocks are the number of error correction
+// codewords in each block, and the number of blocks, of codes of each version
+// (indexed from 1) and error correction level.
+var (
+	eccCodewordsPerBlock = [4][41]int{
+		L: {-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		M: {-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		Q: {-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		H: {-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	numBlocks = [4][41]int{
+		L: {-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		M: {-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		Q: {-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		H: {-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// numRawDataModules returns the number of modules of a code of the specified
+// version which are available for data and error correction codewords, after
+// excluding function patterns.  Some versions have remainder bits which are
+// not part of any codeword.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns the number of data codewords in a code of the
+// specified version and error correction level.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numBlocks[level][version]
+}
+
+// alignmentPositions returns the row and column coordinates of the centers of
+// the alignment patterns of a code of the specified version.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	size := 4*version + 17
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i := numAlign - 1; i >= 1; i-- {
+		positions[i] = size - 7 - (numAlign-1-i)*step
+	}
+	return positions
+}
+
+// set sets the module at column x and row y as a function module.
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing, and alignment patterns, and
+// the version information, and reserves the format information area.
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				c.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				// overlaps a finder pattern
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormatBits(0) // reserve the area, drawn again once masked
+	c.drawVersion()
+}
+
+// drawFormatBits draws both copies of the format information for the code's
+// error correction level and the specified mask.
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInfo(c.Level, mask)
+
+	// first copy, around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(bits, i))
+	}
+	c.set(8, 7, bit(bits, 6))
+	c.set(8, 8, bit(bits, 7))
+	c.set(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(bits, i))
+	}
+
+	// second copy, split between the other finder patterns
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(bits, i))
+	}
+	c.set(8, c.Size-8, true) // always dark
+}
+
+// drawVersion draws both copies of the version information, which is only
+// present in codes of version 7 and up.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionInfo(c.Version)
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, bit(bits, i))
+		c.set(b, a, bit(bits, i))
+	}
+}
+
+// formatInfo returns the 15 bit format information for the error correction
+// level and mask, protected by a BCH code and masked so that it is never all
+// zero.
+func formatInfo(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionInfo returns the 18 bit version information for version, protected by
+// a BCH code.
+func versionInfo(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+// addErrorCorrection splits data into blocks, appends the error correction
+// codewords of each block, and interleaves the blocks.
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	blocks := numBlocks[c.Level][c.Version]
+	eccLen := eccCodewordsPerBlock[c.Level][c.Version]
+	raw := numRawDataModules(c.Version) / 8
+	numShort := blocks - raw%blocks
+	shortLen := raw / blocks // length of short blocks including ecc
+
+	divisor := rsDivisor(eccLen)
+	var dataBlocks, eccBlocks [][]byte
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dataBlocks = append(dataBlocks, data[k:k+n])
+		eccBlocks = append(eccBlocks, rsRemainder(data[k:k+n], divisor))
+		k += n
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				result = append(result, b[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, b := range eccBlocks {
+			result = append(result, b[i])
+		}
+	}
+	return result
+}
+
+// drawCodewords draws the bits of data in the zigzag order of the data area.
+// Any remainder bits are left light.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// skip the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = data[i>>3]>>(7-uint(i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern.  Applying
+// the same mask twice has no effect.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty returns the penalty score of the code, used to choose the mask
+// which makes the code easiest to read.
+func (c *Code) penalty() int {
+	p := 0
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if vertical {
+					line[j] = c.Black(i, j)
+				} else {
+					line[j] = c.Black(j, i)
+				}
+			}
+			p += linePenalty(line)
+		}
+	}
+
+	// 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			b := c.Black(x, y)
+			if b {
+				dark++
+			}
+			if x < c.Size-1 && y < c.Size-1 && b == c.Black(x+1, y) && b == c.Black(x, y+1) && b == c.Black(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+
+	// proportion of dark modules, for each 5% away from 50%
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return p + 10*k
+}
+
+// finderLike is the 1:1:3:1:1 pattern of finder patterns, which is penalized
+// when preceded or followed by four light modules.
+var finderLike = []bool{true, false, true, true, true, false, true}
+
+// linePenalty returns the penalty for runs of the same color and finder-like
+// patterns in a single row or column.
+func linePenalty(line []bool) int {
+	p := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			p += 3 + run - 5
+		}
+		run = 1
+	}
+
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i+len(finderLike) <= len(line); i++ {
+		match := true
+		for j, b := range finderLike {
+			if line[i+j] != b {
+				match = false
+				break
+			}
+		}
+		if match && (light(i-4, i) || light(i+len(finderLike), i+len(finderLike)+4)) {
+			p += 40
+		}
+	}
+	return p
+}
+
+// rsDivisor returns the coefficients of the Reed-Solomon generator polynomial
+// of the specified degree, from highest to lowest power and excluding the
+// leading coefficient, which is always 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		// multiply the current product by (x - root)
+		for j := 0; j < degree; j++ {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply returns the product of x and y in GF(2^8) modulo the QR code
+// polynomial x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// bitBuffer is a sequence of bits, each stored in its own byte.
+type bitBuffer []byte
+
+// append appends the n low bits of v, most significant first.
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, byte(v>>uint(i)&1))
+	}
+}
+
+// bytes packs the bits of bb into bytes.  len(bb) must be a multiple of 8.
+func (bb bitBuffer) bytes() []byte {
+	b := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		b[i/8] |= bit << (7 - uint(i%8))
+	}
+	return b
+}
+
+// bit reports whether bit i of x is set.
+func bit(x, i int) bool {
+	return x>>uint(i)&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
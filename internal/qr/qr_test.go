@@ -0,0 +1,265 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// data and error correction codewords of "HELLO WORLD" as a 1-M code
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder returned %v, want %v", got, want)
+	}
+}
+
+func TestFormatInfo(t *testing.T) {
+	tests := []struct {
+		level Level
+		mask  int
+		want  int
+	}{
+		{L, 0, 0x77C4}, // 111011111000100
+		{M, 0, 0x5412}, // 101010000010010
+		{Q, 0, 0x355F}, // 011010101011111
+		{H, 0, 0x1689}, // 001011010001001
+		{L, 7, 0x6976}, // 110100101110110
+	}
+	for _, tt := range tests {
+		if got := formatInfo(tt.level, tt.mask); got != tt.want {
+			t.Errorf("formatInfo(%v, %d) returned %015b, want %015b", tt.level, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	tests := []struct {
+		version, want int
+	}{
+		{7, 0x07C94},
+		{8, 0x085BC},
+		{40, 0x28C69},
+	}
+	for _, tt := range tests {
+		if got := versionInfo(tt.version); got != tt.want {
+			t.Errorf("versionInfo(%d) returned %018b, want %018b", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestAlignmentPositions(t *testing.T) {
+	tests := []struct {
+		version int
+		want    []int
+	}{
+		{1, nil},
+		{2, []int{6, 18}},
+		{7, []int{6, 22, 38}},
+		{19, []int{6, 30, 58, 86}},
+		{32, []int{6, 34, 60, 86, 112, 138}},
+		{40, []int{6, 30, 58, 86, 114, 142, 170}},
+	}
+	for _, tt := range tests {
+		if got := alignmentPositions(tt.version); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("alignmentPositions(%d) returned %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestEncode_Version(t *testing.T) {
+	// byte mode capacities of the largest versions below each boundary
+	tests := []struct {
+		n       int
+		level   Level
+		version int
+	}{
+		{0, L, 1},
+		{17, L, 1},
+		{18, L, 2},
+		{14, M, 1},
+		{7, H, 1},
+		{8, H, 2},
+		{271, L, 10},
+		{718, L, 18},
+		{719, L, 19},
+		{2953, L, 40},
+		{1273, H, 40},
+	}
+	for _, tt := range tests {
+		c, err := Encode(bytes.Repeat([]byte("x"), tt.n), tt.level)
+		if err != nil {
+			t.Errorf("Encode(%d bytes, %v) returned error: %v", tt.n, tt.level, err)
+			continue
+		}
+		if c.Version != tt.version || c.Size != 4*tt.version+17 {
+			t.Errorf("Encode(%d bytes, %v) returned version %d and size %d, want version %d", tt.n, tt.level, c.Version, c.Size, tt.version)
+		}
+	}
+
+	for _, tt := range []struct {
+		n     int
+		level Level
+	}{{2954, L}, {1274, H}} {
+		if _, err := Encode(bytes.Repeat([]byte("x"), tt.n), tt.level); err != ErrTooLong {
+			t.Errorf("Encode(%d bytes, %v) returned error %v, want %v", tt.n, tt.level, err, ErrTooLong)
+		}
+	}
+}
+
+func TestEncode_FunctionPatterns(t *testing.T) {
+	c, err := Encode([]byte("https://example.com/t4Uh2"), Q)
+	if err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+
+	// finder patterns in three corners, surrounded by light separators
+	for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				d := max(abs(dx), abs(dy))
+				if got, want := c.Black(p[0]+dx, p[1]+dy), d != 2 && d != 4; got != want {
+					t.Errorf("module (%d, %d) is %t, want %t", p[0]+dx, p[1]+dy, got, want)
+				}
+			}
+		}
+	}
+
+	// timing patterns between the finder patterns
+	for i := 8; i < c.Size-8; i++ {
+		if c.Black(i, 6) != (i%2 == 0) || c.Black(6, i) != (i%2 == 0) {
+			t.Errorf("timing pattern module %d is incorrect", i)
+		}
+	}
+
+	// both copies of the format information match
+	bits := formatInfo(c.Level, c.Mask)
+	for i := 0; i < 8; i++ {
+		if c.Black(c.Size-1-i, 8) != bit(bits, i) {
+			t.Errorf("format information bit %d is incorrect", i)
+		}
+	}
+	if !c.Black(8, c.Size-8) {
+		t.Errorf("dark module is not dark")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"L", "m", "Q", "h"} {
+		l, err := ParseLevel(s)
+		if err != nil || l.String() != strings.ToUpper(s) {
+			t.Errorf("ParseLevel(%q) returned %v, %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Errorf("ParseLevel(%q) did not return expected error", "X")
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	c, _ := Encode([]byte("gum"), M)
+	var buf bytes.Buffer
+	if err := c.WritePNG(&buf, 3); err != nil {
+		t.Fatalf("WritePNG returned error: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("error decoding PNG: %v", err)
+	}
+	if got, want := img.Bounds().Dx(), (c.Size+2*QuietZone)*3; got != want {
+		t.Errorf("PNG is %d pixels wide, want %d", got, want)
+	}
+	// top left corner of the finder pattern, after the quiet zone
+	for _, p := range []struct {
+		x, y int
+		dark bool
+	}{{0, 0, false}, {11, 11, false}, {12, 12, true}, {14, 14, true}} {
+		r, _, _, _ := img.At(p.x, p.y).RGBA()
+		if dark := r == 0; dark != p.dark {
+			t.Errorf("pixel (%d, %d) dark is %t, want %t", p.x, p.y, dark, p.dark)
+		}
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	c, _ := Encode([]byte("gum"), M)
+	var buf bytes.Buffer
+	if err := c.WriteSVG(&buf, 200); err != nil {
+		t.Fatalf("WriteSVG returned error: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`width="200" height="200" viewBox="0 0 29 29"`,
+		// first row of the top left finder pattern
+		`M4 4h7v1h-7z`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteSVG output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEncode_Golden(t *testing.T) {
+	// The golden codes in testdata were encoded independently, with
+	// rsc.io/qr, using the same version and mask.  Each row is one line,
+	// with "#" for dark modules and "." for light ones.
+	tests := []struct {
+		data    string
+		level   Level
+		version int
+		mask    int
+		file    string
+	}{
+		{"https://g.co/a", M, 1, 0, "1-M.txt"},
+		{"https://example.com/abc", L, 2, 6, "2-L.txt"},
+		{"https://example.com/posts/2014/02/gum", Q, 4, 3, "4-Q.txt"},
+		{"https://example.com/2014/02/short-urls-for-everything-q", Q, 5, 2, "5-Q.txt"},
+		{"https://willnorris.com/2014/02/short-urls-for-everything", H, 6, 2, "6-H.txt"},
+		{strings.Repeat("0123456789", 20), L, 9, 2, "9-L.txt"},
+	}
+	for _, tt := range tests {
+		golden, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatalf("error reading golden code: %v", err)
+		}
+		rows := strings.Split(strings.TrimSpace(string(golden)), "\n")
+
+		c, err := Encode([]byte(tt.data), tt.level)
+		if err != nil {
+			t.Fatalf("Encode(%q, %v) returned error: %v", tt.data, tt.level, err)
+		}
+		if c.Version != tt.version || c.Mask != tt.mask || c.Size != len(rows) {
+			t.Errorf("Encode(%q, %v) returned version %d, mask %d, size %d, want %d, %d, %d",
+				tt.data, tt.level, c.Version, c.Mask, c.Size, tt.version, tt.mask, len(rows))
+			continue
+		}
+
+		// compare every module, including the data and error correction
+		// codewords as well as the function patterns.
+		var diffs int
+		for y, row := range rows {
+			for x := 0; x < c.Size; x++ {
+				if want := x < len(row) && row[x] == '#'; c.Black(x, y) != want {
+					if diffs == 0 {
+						t.Errorf("Encode(%q, %v) module (%d, %d) is dark %v, want %v", tt.data, tt.level, x, y, !want, want)
+					}
+					diffs++
+				}
+			}
+		}
+		if diffs > 0 {
+			t.Errorf("Encode(%q, %v) differs from %s in %d modules", tt.data, tt.level, tt.file, diffs)
+		}
+	}
+}
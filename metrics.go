@@ -0,0 +1,189 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics are the counters gum is instrumented with.  Counters are shared by
+// all servers and handlers in the process, as with the default registry of
+// the Prometheus client library.
+var metrics = struct {
+	// counters are accessed atomically, so they come first to keep them
+	// 64-bit aligned on 32-bit platforms.
+	gone          counter
+	notFound      counter
+	parseErrors   counter
+	watcherErrors counter
+	reloadErrors  counter
+
+	redirects      counterVec
+	mappingUpdates counterVec
+	reloads        summary
+}{}
+
+// counter is a monotonically increasing count.  Its value is accessed
+// atomically, so a counter must be 64-bit aligned, such as by being the first
+// field of a struct or following other counters.
+type counter struct {
+	n uint64
+}
+
+func (c *counter) inc()          { atomic.AddUint64(&c.n, 1) }
+func (c *counter) value() uint64 { return atomic.LoadUint64(&c.n) }
+
+// counterVec is a set of counters, identified by the values of their labels.
+type counterVec struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+// inc increments the counter with the specified label names and values,
+// which alternate.
+func (v *counterVec) inc(labels ...string) {
+	key := formatLabels(labels...)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]uint64)
+	}
+	v.counts[key]++
+}
+
+// value returns the counter with the specified label names and values.
+func (v *counterVec) value(labels ...string) uint64 {
+	key := formatLabels(labels...)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.counts[key]
+}
+
+// snapshot returns the formatted labels and values of each counter in v.
+func (v *counterVec) snapshot() map[string]float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	values := make(map[string]float64, len(v.counts))
+	for k, n := range v.counts {
+		values[k] = float64(n)
+	}
+	return values
+}
+
+// summary is the count and sum of a set of observed durations.
+type summary struct {
+	mutex sync.Mutex
+	count uint64
+	sum   float64
+}
+
+func (s *summary) observe(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	s.sum += d.Seconds()
+}
+
+// formatLabels returns the Prometheus label set for the alternating label
+// names and values.
+func formatLabels(labels ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as required by the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricWriter writes metrics in the Prometheus text exposition format.
+type metricWriter struct {
+	w *bufio.Writer
+}
+
+// write writes a metric family with the specified name, help text, type, and
+// values keyed by their formatted labels.
+func (mw metricWriter) write(name, help, typ string, values map[string]float64) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(mw.w, "%s%s %s\n", name, k, formatValue(values[k]))
+	}
+}
+
+// writeSummary writes a summary metric with the specified name and help text.
+func (mw metricWriter) writeSummary(name, help string, s *summary) {
+	s.mutex.Lock()
+	count, sum := s.count, s.sum
+	s.mutex.Unlock()
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+	fmt.Fprintf(mw.w, "%s_sum %s\n%s_count %d\n", name, formatValue(sum), name, count)
+}
+
+// single returns values for a metric without labels.
+func single(v float64) map[string]float64 {
+	return map[string]float64{"": v}
+}
+
+// formatValue formats v as a Prometheus sample value.
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// WriteMetrics writes gum's metrics, and the number of mappings served by s by
+// source kind, to w in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	mappings := make(map[string]float64)
+	for _, k := range []SourceKind{SourceTombstone, SourceStatic, SourceManual, SourceConfig} {
+		mappings[formatLabels("source", k.String())] = 0
+	}
+	for _, m := range s.Mappings() {
+		mappings[formatLabels("source", m.Source.Kind.String())]++
+	}
+
+	mw := metricWriter{w: bufio.NewWriter(w)}
+	mw.write("gum_redirects_total", "Redirects served, by handler and source.", "counter",
+		metrics.redirects.snapshot())
+	mw.write("gum_gone_total", "Requests for retired short URLs.", "counter",
+		single(float64(metrics.gone.value())))
+	mw.write("gum_not_found_total", "Requests which matched no mapping or handler.", "counter",
+		single(float64(metrics.notFound.value())))
+	mw.write("gum_mappings", "Short URLs in the mapping table, by source of the mapping served.", "gauge",
+		mappings)
+	mw.write("gum_mapping_updates_total", "Mappings applied from handlers, by operation.", "counter",
+		metrics.mappingUpdates.snapshot())
+	mw.write("gum_static_parse_errors_total", "Static files which could not be parsed.", "counter",
+		single(float64(metrics.parseErrors.value())))
+	mw.write("gum_static_watcher_errors_total", "Errors reported by static file watchers.", "counter",
+		single(float64(metrics.watcherErrors.value())))
+
+	mw.writeSummary("gum_reload_duration_seconds", "Time taken to load handlers on reload.",
+		&metrics.reloads)
+	mw.write("gum_reload_errors_total", "Reloads which failed.", "counter",
+		single(float64(metrics.reloadErrors.value())))
+
+	return mw.w.Flush()
+}
@@ -0,0 +1,80 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		labels []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"a", "b"}, `{a="b"}`},
+		{[]string{"a", "b", "c", "d"}, `{a="b",c="d"}`},
+		{[]string{"a", "x\"y\\z\n"}, `{a="x\"y\\z\n"}`},
+	}
+
+	for _, tt := range tests {
+		if got := formatLabels(tt.labels...); got != tt.want {
+			t.Errorf("formatLabels(%q) returned %s, want %s", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestServer_WriteMetrics(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	g.AddMapping(Mapping{ShortPath: "/g", Status: 410})
+	rh, _ := NewRedirectHandler("metrics", "/m/")
+	if err := g.Reload(rh); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	mappingRedirects := metrics.redirects.value("handler", "mapping", "source", "manual")
+	handlerRedirects := metrics.redirects.value("handler", "redirect", "source", "/metrics")
+	gone, notFound := metrics.gone.value(), metrics.notFound.value()
+
+	for _, path := range []string{"/a", "/a", "/g", "/metrics/x", "/nope"} {
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if got, want := metrics.redirects.value("handler", "mapping", "source", "manual")-mappingRedirects, uint64(2); got != want {
+		t.Errorf("mapping redirects increased by %d, want %d", got, want)
+	}
+	if got, want := metrics.redirects.value("handler", "redirect", "source", "/metrics")-handlerRedirects, uint64(1); got != want {
+		t.Errorf("redirect handler redirects increased by %d, want %d", got, want)
+	}
+	if got, want := metrics.gone.value()-gone, uint64(1); got != want {
+		t.Errorf("gone responses increased by %d, want %d", got, want)
+	}
+	if got, want := metrics.notFound.value()-notFound, uint64(1); got != want {
+		t.Errorf("not found responses increased by %d, want %d", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics returned error: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE gum_redirects_total counter\n",
+		`gum_redirects_total{handler="redirect",source="/metrics"} `,
+		"gum_mappings{source=\"manual\"} 2\n",
+		"gum_mappings{source=\"static\"} 0\n",
+		"# TYPE gum_reload_duration_seconds summary\n",
+		"gum_reload_duration_seconds_count ",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteMetrics output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// serverKey is the context key of the Server serving a request.
+type serverKey struct{}
+
+// NotFound replies to a request for a short URL which no mapping or handler
+// matches.  The unmatched host and path are logged, and the request is served
+// by the not found handler of the Server serving the request (see
+// Server.SetNotFound), or with a plain 404 (Not Found) response if it has
+// none.  Handlers which only match some of the requests they are registered
+// for should call NotFound for the others.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Not found: %v%v", r.Host, r.URL.RequestURI())
+	metrics.notFound.inc()
+	setAccessEntry(r, "not_found", "")
+
+	if s, ok := r.Context().Value(serverKey{}).(*Server); ok {
+		s.mutex.RLock()
+		h := s.notFound
+		s.mutex.RUnlock()
+		if h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// NotFoundData is the data passed to the templates of NotFoundTemplate.
+type NotFoundData struct {
+	// Host is the host of the request.
+	Host string
+
+	// Path is the path of the request.
+	Path string
+}
+
+// NotFoundTemplate returns a handler which responds with a 404 (Not Found)
+// status and the result of executing t with NotFoundData for the request.
+func NotFoundTemplate(t *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, NotFoundData{Host: r.Host, Path: r.URL.Path}); err != nil {
+			log.Printf("error executing not found template: %v", err)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(buf.Bytes())
+	})
+}
+
+// pathParam is the placeholder for the request path in the destination of
+// NotFoundRedirect.
+const pathParam = "{path}"
+
+// NotFoundRedirect returns a handler which redirects requests to destination
+// with the specified status.  Any "{path}" in destination is replaced with
+// the request path, which is escaped as a query parameter if it appears in
+// the query of destination, such as "https://example.com/search?q={path}".
+func NotFoundRedirect(destination string, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dest := destination
+		if i := strings.Index(dest, pathParam); i >= 0 {
+			path := r.URL.EscapedPath()
+			if strings.Contains(dest[:i], "?") {
+				path = url.QueryEscape(r.URL.Path)
+			}
+			dest = strings.Replace(dest, pathParam, path, -1)
+		}
+		http.Redirect(w, r, dest, status)
+	})
+}
+
+// NotFoundProxy returns a handler which proxies requests to the upstream
+// server at the specified base URL, such as the main site of a short domain.
+// Requests are sent with the host of upstream.
+func NotFoundProxy(upstream *url.URL) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+	}
+	return proxy
+}
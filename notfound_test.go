@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestServer_NotFound(t *testing.T) {
+	g := NewServer()
+	if err := g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"}); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+	rule, err := NewRule("/i/{id:[0-9]+}", "/issues/{id}")
+	if err != nil {
+		t.Fatalf("NewRule returned error: %v", err)
+	}
+	if err := g.AddHandler(NewRuleHandler(rule)); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	tmpl := template.Must(template.New("").Parse(`no {{.Path}} on {{.Host}}`))
+
+	tests := []struct {
+		notFound http.Handler
+		in       string
+		code     int
+		location string
+		body     string
+	}{
+		// mappings and matching rules are unaffected
+		{NotFoundTemplate(tmpl), "/a", http.StatusMovedPermanently, "/pa", ""},
+		{NotFoundTemplate(tmpl), "/i/1", http.StatusMovedPermanently, "/issues/1", ""},
+
+		{nil, "/b", http.StatusNotFound, "", "404 page not found\n"},
+		{NotFoundTemplate(tmpl), "/b", http.StatusNotFound, "", "no /b on example.com"},
+		{NotFoundTemplate(tmpl), "/i/x", http.StatusNotFound, "", "no /i/x on example.com"},
+		{NotFoundRedirect("https://example.org/search?q={path}", http.StatusFound), "/b/c", http.StatusFound, "https://example.org/search?q=%2Fb%2Fc", ""},
+		{NotFoundRedirect("https://example.org{path}", http.StatusFound), "/b%20c", http.StatusFound, "https://example.org/b%20c", ""},
+		{NotFoundRedirect("https://example.org/", http.StatusMovedPermanently), "/b", http.StatusMovedPermanently, "https://example.org/", ""},
+		{NotFoundProxy(upstreamURL), "/b", http.StatusTeapot, "", "upstream /b"},
+	}
+
+	for _, tt := range tests {
+		g.SetNotFound(tt.notFound)
+		resp := httptest.NewRecorder()
+		g.ServeHTTP(resp, httptest.NewRequest("GET", "http://example.com"+tt.in, nil))
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("request for %q returned status %v, want %v", tt.in, got, want)
+		}
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("request for %q redirected to %q, want %q", tt.in, got, want)
+		}
+		if tt.body != "" {
+			if got, want := resp.Body.String(), tt.body; got != want {
+				t.Errorf("request for %q returned body %q, want %q", tt.in, got, want)
+			}
+		}
+	}
+}
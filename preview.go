@@ -0,0 +1,174 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// preview describes where a short URL redirects to, for the preview page.
+type preview struct {
+	// Host and Path are the host and path of the previewed short URL.
+	Host, Path string
+
+	// Destination is the URL the short URL redirects to.  For retired
+	// short URLs, it is the permalink they used to redirect to, if known.
+	Destination string
+
+	// Status is the HTTP status of the redirect, or 410 (Gone) for
+	// retired short URLs.
+	Status int
+
+	// Source describes the mapping or handler which serves the short URL.
+	Source string
+
+	// Title is the title of the destination page, if known.
+	Title string
+}
+
+// ShortURL returns the host and path of the previewed short URL.
+func (p preview) ShortURL() string {
+	return p.Host + p.Path
+}
+
+// Gone reports whether the previewed short URL has been retired.
+func (p preview) Gone() bool {
+	return p.Status == http.StatusGone
+}
+
+// previewer is implemented by handlers which can describe the redirect they
+// would serve for a request without serving it.
+type previewer interface {
+	// preview describes the redirect for r, or returns false if the
+	// handler does not redirect r.  The host and path of the returned
+	// preview are ignored.
+	preview(r *http.Request) (preview, bool)
+}
+
+// inspectRequest returns the request for the short URL inspected by r, if r
+// is a request to inspect a short URL rather than follow it, along with the
+// value of the query parameter param.  Any short URL can be inspected by adding
+// the query parameter, and short URLs of mappings can also be inspected by
+// appending suffix to the path.  Paths which end in suffix are only inspected
+// if the path itself is not a mapping, and the path without the suffix is.
+func (s *Server) inspectRequest(r *http.Request, param, suffix string) (*http.Request, string, bool) {
+	u := *r.URL
+	var value string
+	if query := u.Query(); query[param] != nil {
+		value = query.Get(param)
+		query.Del(param)
+		u.RawQuery = query.Encode()
+	} else if strings.HasSuffix(u.Path, suffix) {
+		u.Path, u.RawPath = strings.TrimSuffix(u.Path, suffix), ""
+		if _, ok := s.Lookup(r.Host, r.URL.Path); ok {
+			return nil, "", false
+		}
+		if _, ok := s.Lookup(r.Host, u.Path); !ok {
+			return nil, "", false
+		}
+	} else {
+		return nil, "", false
+	}
+
+	ir := r.WithContext(r.Context())
+	ir.URL = &u
+	return ir, value, true
+}
+
+// describe describes the redirect served for the short URL requested by r,
+// returning the short URL or handler pattern which matches it, or false if
+// nothing matches it.
+func (s *Server) describe(r *http.Request) (preview, string, bool) {
+	if m, ok := s.Lookup(r.Host, r.URL.Path); ok {
+		p := preview{
+			Host:   m.Host,
+			Path:   m.ShortPath,
+			Status: m.status(),
+			Source: m.Source.String(),
+			Title:  m.Title,
+		}
+		if p.Host == "" {
+			p.Host = r.Host
+		}
+		if p.Gone() {
+			p.Destination = m.Permalink
+		} else {
+			p.Destination = m.Query.redirectURL(m.Permalink, r.URL.RawQuery, QueryDrop)
+		}
+		return p, m.Host + m.ShortPath, true
+	}
+
+	s.mutex.RLock()
+	mux := s.mux
+	s.mutex.RUnlock()
+
+	if h, pattern := mux.Handler(r); pattern != "" {
+		if pv, ok := h.(previewer); ok {
+			if p, ok := pv.preview(r); ok {
+				p.Host, p.Path = r.Host, r.URL.Path
+				return p, pattern, true
+			}
+		}
+	}
+	return preview{}, "", false
+}
+
+// servePreview serves a page describing where the short URL requested by r
+// redirects to, rather than redirecting.  How the request was served is
+// described in e.
+func (s *Server) servePreview(w http.ResponseWriter, r *http.Request, e *accessEntry) {
+	e.handler = "preview"
+	p, match, ok := s.describe(r)
+	if !ok {
+		NotFound(w, r.WithContext(context.WithValue(r.Context(), serverKey{}, s)))
+		return
+	}
+	e.match = match
+	writePreview(w, p)
+}
+
+// writePreview writes the preview page for p.
+func writePreview(w http.ResponseWriter, p preview) {
+	var buf bytes.Buffer
+	if err := previewTemplate.Execute(&buf, p); err != nil {
+		log.Printf("error rendering preview of %v: %v", p.ShortURL(), err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Preview of {{.ShortURL}}</title>
+</head>
+<body>
+<h1>{{.ShortURL}}</h1>
+{{if .Gone}}
+<p>This short URL has been retired{{if .Destination}}, and used to redirect to <code>{{.Destination}}</code>{{end}}.</p>
+{{else}}
+<p>This short URL redirects to:</p>
+<p><a href="{{.Destination}}" rel="nofollow">{{if .Title}}{{.Title}}{{else}}{{.Destination}}{{end}}</a></p>
+{{if .Title}}<p><code>{{.Destination}}</code></p>{{end}}
+{{end}}
+<dl>
+<dt>Status</dt><dd>{{.Status}}</dd>
+<dt>Source</dt><dd>{{.Source}}</dd>
+</dl>
+</body>
+</html>
+`))
@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Preview(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "https://example.com/a", Title: "Post <A>"})
+	g.AddMapping(Mapping{ShortPath: "/c++", Permalink: "/cpp"})
+	g.AddMapping(Mapping{ShortPath: "/g", Permalink: "/old", Status: http.StatusGone})
+	rh, _ := NewRedirectHandler("w", "https://en.wikipedia.org/wiki/")
+	rule, _ := NewRule("/i/{id:[0-9]+}", "/issues/{id}")
+	if err := g.Reload(rh, NewRuleHandler(rule)); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	tests := []struct {
+		url      string
+		code     int
+		location string
+		contains []string
+	}{
+		{"/a+", http.StatusOK, "", []string{
+			`<a href="https://example.com/a" rel="nofollow">Post &lt;A&gt;</a>`,
+			"<dd>301</dd>", "<dd>manual</dd>",
+		}},
+		{"/a?preview", http.StatusOK, "", []string{`href="https://example.com/a"`}},
+		{"/g+", http.StatusOK, "", []string{"has been retired", "<code>/old</code>", "<dd>410</dd>"}},
+		{"/w/Gum?preview", http.StatusOK, "", []string{
+			`href="https://en.wikipedia.org/wiki/Gum"`, "<dd>redirect /w</dd>",
+		}},
+		{"/w/Gum?preview&x=1", http.StatusOK, "", []string{`href="https://en.wikipedia.org/wiki/Gum?x=1"`}},
+		{"/i/1?preview", http.StatusOK, "", []string{`href="/issues/1"`, "<dd>rule /i/{id:[0-9]&#43;}</dd>"}},
+		{"/i/x?preview", http.StatusNotFound, "", nil},
+		{"/nope?preview", http.StatusNotFound, "", nil},
+
+		// "+" only previews mappings, and not paths which are mappings
+		{"/c++", http.StatusMovedPermanently, "/cpp", nil},
+		{"/c+++", http.StatusOK, "", []string{`href="/cpp"`}},
+		{"/w/C++", http.StatusMovedPermanently, "https://en.wikipedia.org/wiki/C++", nil},
+		{"/nope+", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s returned status %d, want %d", tt.url, w.Code, tt.code)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("GET %s returned Location %q, want %q", tt.url, got, tt.location)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("GET %s body does not contain %q:\n%s", tt.url, want, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestServer_Preview_NoClick(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	tracker := NewClickTracker(nil)
+	g.SetClickTracker(tracker)
+
+	var buf bytes.Buffer
+	l, _ := NewAccessLog(&buf, LogCombined)
+	g.SetAccessLog(l)
+
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a+", nil))
+	if clicks, _ := tracker.Clicks(ClickFilter{}); len(clicks) != 0 {
+		t.Errorf("preview counted clicks %v, want none", clicks)
+	}
+	if got := buf.String(); !strings.Contains(got, `"/a" "-" preview `) {
+		t.Errorf("access log entry %q is not for a preview of /a", got)
+	}
+}
+
+func TestParsePage_Title(t *testing.T) {
+	tests := []struct {
+		html, want string
+	}{
+		{`<title>Hello</title>`, "Hello"},
+		{"<title>\n  Hello,\n  world  </title><title>Second</title>", "Hello, world"},
+		{`<title>A &amp; B</title>`, "A & B"},
+		{`<body><svg><title>Icon</title></svg></body>`, ""},
+	}
+	for _, tt := range tests {
+		p, err := parsePage(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatalf("parsePage(%q) returned error: %v", tt.html, err)
+		}
+		if p.title != tt.want {
+			t.Errorf("parsePage(%q) returned title %q, want %q", tt.html, p.title, tt.want)
+		}
+	}
+}
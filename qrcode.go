@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"willnorris.com/go/gum/internal/qr"
+)
+
+const (
+	// defaultQRSize is the width in pixels of QR code images when no size
+	// is requested.
+	defaultQRSize = 256
+
+	// maxQRSize is the largest width in pixels of QR code images which may
+	// be requested.
+	maxQRSize = 2048
+)
+
+// serveQR serves a QR code image of the canonical URL of the short URL
+// requested by r, in the requested format ("png" or "svg", defaulting to
+// "png").  The image size and error correction level are read from the
+// "size" and "ec" query parameters, which are removed from the encoded URL.
+// PNG images are rounded down to a whole number of pixels per module.
+// How the request was served is described in e.
+func (s *Server) serveQR(w http.ResponseWriter, r *http.Request, format string, e *accessEntry) {
+	e.handler = "qr"
+
+	format = strings.ToLower(format)
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, fmt.Sprintf("unsupported QR code format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	size, level, err := qrParams(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Del("size")
+	query.Del("ec")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	r = r.WithContext(r.Context())
+	r.URL = &u
+
+	p, match, ok := s.describe(r)
+	if !ok {
+		NotFound(w, r.WithContext(context.WithValue(r.Context(), serverKey{}, s)))
+		return
+	}
+	e.match = match
+	if p.Gone() {
+		s.serveGone(w, r)
+		return
+	}
+
+	short := url.URL{Scheme: requestScheme(r), Host: p.Host, Path: p.Path, RawQuery: u.RawQuery}
+	code, err := qr.Encode([]byte(short.String()), level)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot encode %s: %v", short.String(), err), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(&buf, size)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		err = code.WritePNG(&buf, size/(code.Size+2*qr.QuietZone))
+	}
+	if err != nil {
+		log.Printf("error rendering QR code for %v: %v", short.String(), err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// qrParams returns the image size and error correction level requested by the
+// "size" and "ec" query parameters.
+func qrParams(query url.Values) (size int, level qr.Level, err error) {
+	size, level = defaultQRSize, qr.M
+	if v := query.Get("size"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || size < 1 || size > maxQRSize {
+			return 0, 0, fmt.Errorf("invalid QR code size %q: must be between 1 and %d", v, maxQRSize)
+		}
+	}
+	if v := query.Get("ec"); v != "" {
+		level, err = qr.ParseLevel(v)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return size, level, nil
+}
+
+// requestScheme returns the scheme r was made with, trusting the
+// X-Forwarded-Proto header set by proxies which terminate TLS.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
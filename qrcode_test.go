@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"willnorris.com/go/gum/internal/qr"
+)
+
+func TestServer_QR(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "https://example.com/a"})
+	g.AddMapping(Mapping{Host: "s.example", ShortPath: "/b", Permalink: "/pb"})
+	g.AddMapping(Mapping{ShortPath: "/g", Permalink: "/old", Status: http.StatusGone})
+	g.AddMapping(Mapping{ShortPath: "/x.qr", Permalink: "/px"})
+	rh, _ := NewRedirectHandler("w", "https://en.wikipedia.org/wiki/")
+	if err := g.Reload(rh); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+
+	// image returns the expected QR code of url, rendered as format.
+	image := func(url, format string, size int, level qr.Level) []byte {
+		c, err := qr.Encode([]byte(url), level)
+		if err != nil {
+			t.Fatalf("Encode(%q) returned error: %v", url, err)
+		}
+		var buf bytes.Buffer
+		if format == "svg" {
+			c.WriteSVG(&buf, size)
+		} else {
+			c.WritePNG(&buf, size/(c.Size+2*qr.QuietZone))
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		url         string
+		header      http.Header
+		tls         bool
+		code        int
+		contentType string
+		body        []byte
+	}{
+		{"/a.qr", nil, false, http.StatusOK, "image/png", image("http://example.com/a", "png", defaultQRSize, qr.M)},
+		{"/a?qr", nil, false, http.StatusOK, "image/png", image("http://example.com/a", "png", defaultQRSize, qr.M)},
+		{"/a?qr=svg&size=100&ec=h", nil, false, http.StatusOK, "image/svg+xml", image("http://example.com/a", "svg", 100, qr.H)},
+		{"/a.qr", nil, true, http.StatusOK, "image/png", image("https://example.com/a", "png", defaultQRSize, qr.M)},
+		{"/a.qr", http.Header{"X-Forwarded-Proto": {"https"}}, false, http.StatusOK, "image/png", image("https://example.com/a", "png", defaultQRSize, qr.M)},
+		{"http://s.example/b?qr=png&size=500", nil, false, http.StatusOK, "image/png", image("http://s.example/b", "png", 500, qr.M)},
+		{"/w/Gum?qr&x=1", nil, false, http.StatusOK, "image/png", image("http://example.com/w/Gum?x=1", "png", defaultQRSize, qr.M)},
+
+		// ".qr" only applies to mappings, and not paths which are mappings
+		{"/x.qr", nil, false, http.StatusMovedPermanently, "", nil},
+		{"/w/Gum.qr", nil, false, http.StatusMovedPermanently, "", nil},
+
+		{"/g.qr", nil, false, http.StatusGone, "", nil},
+		{"/nope?qr", nil, false, http.StatusNotFound, "", nil},
+		{"/a?qr=gif", nil, false, http.StatusBadRequest, "", nil},
+		{"/a?qr&size=0", nil, false, http.StatusBadRequest, "", nil},
+		{"/a?qr&size=big", nil, false, http.StatusBadRequest, "", nil},
+		{"/a?qr&ec=X", nil, false, http.StatusBadRequest, "", nil},
+		{"/w/" + strings.Repeat("x", 3000) + "?qr", nil, false, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tt.url, nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		g.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("GET %s returned status %d, want %d", tt.url, w.Code, tt.code)
+			continue
+		}
+		if tt.contentType == "" {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s returned Content-Type %q, want %q", tt.url, got, tt.contentType)
+		}
+		if !bytes.Equal(w.Body.Bytes(), tt.body) {
+			t.Errorf("GET %s returned unexpected QR code image", tt.url)
+		}
+	}
+}
+
+func TestServer_QR_NoClick(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa"})
+	tracker := NewClickTracker(nil)
+	g.SetClickTracker(tracker)
+
+	var buf bytes.Buffer
+	l, _ := NewAccessLog(&buf, LogCombined)
+	g.SetAccessLog(l)
+
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a.qr", nil))
+	if clicks, _ := tracker.Clicks(ClickFilter{}); len(clicks) != 0 {
+		t.Errorf("QR code counted clicks %v, want none", clicks)
+	}
+	if got := buf.String(); !strings.Contains(got, `"/a" "-" qr `) {
+		t.Errorf("access log entry %q is not for a QR code of /a", got)
+	}
+}
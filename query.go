@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A QueryPolicy specifies how the query string of a request is combined with
+// that of the destination URL it is redirected to.  A policy is a space
+// separated list of an optional mode, followed by parameter names and fixed
+// parameters.  The modes are:
+//
+//     preserve   the request query replaces the destination query, if present
+//     drop       the request query is discarded
+//     merge      request parameters are added to the destination query
+//     allow      like merge, but only the listed request parameters are added
+//
+// Fixed parameters of the form "name=value" are added to the destination
+// query after the mode is applied, replacing any parameters of the same name.
+// For example:
+//
+//     merge utm_source=short
+//     allow id page
+//     drop utm_source=short utm_medium=link
+//
+// If no mode is specified, the default mode of the handler is used.  Mappings
+// default to drop, RedirectHandlers to preserve, and Rules to merge.  A
+// RedirectHandler with no policy at all only uses its destination query for
+// the prefix itself.
+type QueryPolicy string
+
+// Query policy modes.
+const (
+	QueryPreserve QueryPolicy = "preserve"
+	QueryDrop     QueryPolicy = "drop"
+	QueryMerge    QueryPolicy = "merge"
+	QueryAllow    QueryPolicy = "allow"
+)
+
+// queryPolicy is a parsed QueryPolicy.
+type queryPolicy struct {
+	mode   QueryPolicy
+	allow  map[string]bool
+	append url.Values
+}
+
+// parse parses p, using def as the mode if p does not specify one.
+func (p QueryPolicy) parse(def QueryPolicy) (*queryPolicy, error) {
+	qp := &queryPolicy{mode: def, allow: make(map[string]bool), append: make(url.Values)}
+	for i, field := range strings.Fields(string(p)) {
+		switch mode := QueryPolicy(field); {
+		case i == 0 && (mode == QueryPreserve || mode == QueryDrop || mode == QueryMerge || mode == QueryAllow):
+			qp.mode = mode
+		case strings.Contains(field, "="):
+			kv := strings.SplitN(field, "=", 2)
+			name, err := url.QueryUnescape(kv[0])
+			if err != nil || name == "" {
+				return nil, fmt.Errorf("gum: invalid parameter %q in query policy %q", field, p)
+			}
+			value, err := url.QueryUnescape(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("gum: invalid parameter %q in query policy %q", field, p)
+			}
+			qp.append.Add(name, value)
+		case qp.mode == QueryAllow:
+			qp.allow[field] = true
+		default:
+			return nil, fmt.Errorf("gum: unexpected %q in query policy %q", field, p)
+		}
+	}
+	return qp, nil
+}
+
+// Validate returns an error if p is not a valid query policy.
+func (p QueryPolicy) Validate() error {
+	_, err := p.parse(QueryDrop)
+	return err
+}
+
+// apply returns the query string to redirect to, given the raw query of the
+// destination URL and of the request.
+func (qp *queryPolicy) apply(dest, request string) string {
+	query := dest
+	switch qp.mode {
+	case QueryPreserve:
+		if request != "" {
+			query = request
+		}
+	case QueryMerge, QueryAllow:
+		if request == "" {
+			break
+		}
+		if dest == "" && qp.mode == QueryMerge {
+			query = request
+			break
+		}
+		values, _ := url.ParseQuery(dest)
+		reqValues, _ := url.ParseQuery(request)
+		for name, v := range reqValues {
+			if qp.mode == QueryMerge || qp.allow[name] {
+				values[name] = v
+			}
+		}
+		query = values.Encode()
+	}
+
+	if len(qp.append) == 0 {
+		return query
+	}
+	values, _ := url.ParseQuery(query)
+	for name, v := range qp.append {
+		values[name] = v
+	}
+	return values.Encode()
+}
+
+// redirectURL returns dest with its query string replaced by the result of
+// applying p to the query of dest and the request query.  The default mode is
+// used if p does not specify one.  Invalid policies, which are rejected when
+// handlers and mappings are added, are treated as the default mode.
+func (p QueryPolicy) redirectURL(dest, request string, def QueryPolicy) string {
+	qp, err := p.parse(def)
+	if err != nil {
+		qp, _ = QueryPolicy("").parse(def)
+	}
+
+	var fragment string
+	if i := strings.Index(dest, "#"); i >= 0 {
+		dest, fragment = dest[:i], dest[i:]
+	}
+	var query string
+	if i := strings.Index(dest, "?"); i >= 0 {
+		dest, query = dest[:i], dest[i+1:]
+	}
+	if query = qp.apply(query, request); query != "" {
+		dest += "?" + query
+	}
+	return dest + fragment
+}
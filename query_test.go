@@ -0,0 +1,116 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryPolicy(t *testing.T) {
+	tests := []struct {
+		policy    QueryPolicy
+		def       QueryPolicy
+		dest, req string
+		want      string
+	}{
+		// preserve
+		{"preserve", QueryDrop, "/p", "a=1", "/p?a=1"},
+		{"preserve", QueryDrop, "/p?b=2", "a=1", "/p?a=1"},
+		{"preserve", QueryDrop, "/p?b=2", "", "/p?b=2"},
+
+		// drop
+		{"drop", QueryPreserve, "/p", "a=1", "/p"},
+		{"drop", QueryPreserve, "/p?b=2", "a=1", "/p?b=2"},
+
+		// merge
+		{"merge", QueryDrop, "/p", "a=1&c=3", "/p?a=1&c=3"},
+		{"merge", QueryDrop, "/p?b=2", "a=1", "/p?a=1&b=2"},
+		{"merge", QueryDrop, "/p?a=2&b=2", "a=1", "/p?a=1&b=2"},
+		{"merge", QueryDrop, "/p?b=2", "", "/p?b=2"},
+
+		// allow
+		{"allow a", QueryDrop, "/p", "a=1&c=3", "/p?a=1"},
+		{"allow a c", QueryDrop, "/p?b=2", "a=1&c=3&d=4", "/p?a=1&b=2&c=3"},
+		{"allow", QueryDrop, "/p?b=2", "a=1", "/p?b=2"},
+
+		// fixed parameters
+		{"utm_source=short", QueryDrop, "/p", "a=1", "/p?utm_source=short"},
+		{"utm_source=short", QueryPreserve, "/p", "a=1", "/p?a=1&utm_source=short"},
+		{"merge utm_source=short", QueryDrop, "/p?utm_source=x", "utm_source=y", "/p?utm_source=short"},
+		{"allow a utm_source=short utm_medium=link", QueryDrop, "/p", "a=1&b=2", "/p?a=1&utm_medium=link&utm_source=short"},
+		{"drop q=a+b", QueryDrop, "/p", "", "/p?q=a+b"},
+
+		// default mode
+		{"", QueryDrop, "/p", "a=1", "/p"},
+		{"", QueryPreserve, "/p", "a=1", "/p?a=1"},
+		{"", QueryMerge, "/p?b=2", "a=1", "/p?a=1&b=2"},
+
+		// fragments are retained
+		{"merge", QueryDrop, "/p?b=2#f", "a=1", "/p?a=1&b=2#f"},
+		{"preserve", QueryDrop, "/p#f", "a=1", "/p?a=1#f"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); err != nil {
+			t.Errorf("QueryPolicy(%q).Validate() returned error: %v", tt.policy, err)
+		}
+		if got := tt.policy.redirectURL(tt.dest, tt.req, tt.def); got != tt.want {
+			t.Errorf("QueryPolicy(%q).redirectURL(%q, %q, %q) returned %q, want %q", tt.policy, tt.dest, tt.req, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestQueryPolicy_Invalid(t *testing.T) {
+	for _, policy := range []QueryPolicy{"bogus", "merge a", "preserve drop", "=x", "a=%zz"} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("QueryPolicy(%q).Validate() did not return expected error", policy)
+		}
+	}
+}
+
+// Test that query policies are applied to redirects for mappings and redirect
+// handlers.
+func TestServer_QueryPolicy(t *testing.T) {
+	g := NewServer()
+	g.AddMapping(Mapping{ShortPath: "/a", Permalink: "/pa?x=1"})
+	g.AddMapping(Mapping{ShortPath: "/b", Permalink: "/pb", Query: "merge utm_source=short"})
+	h, _ := NewRedirectHandler("r", "/dest/")
+	g.AddHandler(h)
+	h, _ = NewRedirectHandler("s", "/dest/")
+	h.Query = "allow id"
+	g.AddHandler(h)
+	h, _ = NewRedirectHandler("t", "/dest/?t=1")
+	h.Query = "merge"
+	g.AddHandler(h)
+
+	tests := []struct {
+		in, location string
+	}{
+		{"/a?y=2", "/pa?x=1"},
+		{"/b?y=2", "/pb?utm_source=short&y=2"},
+		{"/r/x?y=2", "/dest/x?y=2"},
+		{"/s/x?id=1&y=2", "/dest/x?id=1"},
+		{"/t/x?y=2", "/dest/x?t=1&y=2"},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		g.ServeHTTP(resp, httptest.NewRequest("GET", tt.in, nil))
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("request for %q redirected to %q, want %q", tt.in, got, want)
+		}
+		if got, want := resp.Code, http.StatusMovedPermanently; got != want {
+			t.Errorf("request for %q returned status %v, want %v", tt.in, got, want)
+		}
+	}
+
+	if err := g.AddMapping(Mapping{ShortPath: "/c", Permalink: "/pc", Query: "bogus"}); err == nil {
+		t.Errorf("AddMapping with invalid query policy did not return expected error")
+	}
+}
@@ -7,6 +7,7 @@
 package gum
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -29,6 +30,11 @@ import (
 //
 // The request URL "/x123" would not be handled by this handler.
 type RedirectHandler struct {
+	// Host is the optional host this handler should handle.  If empty,
+	// requests for all hosts are handled.  Handlers with a host take
+	// precedence over those without.
+	Host string
+
 	// Prefix is the path component prefix this handler should handle.
 	// Prefix should not contain leading or trailing slashes.
 	Prefix string
@@ -39,6 +45,12 @@ type RedirectHandler struct {
 
 	// Status is the HTTP status to return in redirect responses.
 	Status int
+
+	// Query is the policy for handling the query string of requests.  If
+	// empty, the request URL is resolved against Destination, so the
+	// request query is preserved and the query of Destination is only used
+	// for requests with no path below the prefix and no query.
+	Query QueryPolicy
 }
 
 // NewRedirectHandler constructs a new RedirectHandler with the specified
@@ -58,24 +70,92 @@ func NewRedirectHandler(prefix, destination string) (*RedirectHandler, error) {
 	return h, nil
 }
 
+// Validate returns an error if h is not a valid redirect handler.
+func (h *RedirectHandler) Validate() error {
+	if strings.Contains(h.Host, "/") {
+		return fmt.Errorf("gum: invalid host %q", h.Host)
+	}
+	if h.Prefix != "/" && (strings.HasPrefix(h.Prefix, "/") || strings.HasSuffix(h.Prefix, "/")) {
+		return fmt.Errorf("gum: prefix %q should not contain leading or trailing slashes", h.Prefix)
+	}
+	if !isRedirectStatus(h.Status) {
+		return fmt.Errorf("gum: status %d is not a valid redirect status", h.Status)
+	}
+	return h.Query.Validate()
+}
+
+// isRedirectStatus reports whether code is an HTTP redirect status code.
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
 func (h *RedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recordClick(r, h.Host, "/"+h.Prefix)
+	metrics.redirects.inc("handler", "redirect", "source", h.Host+"/"+h.Prefix)
+
+	// http.Redirect resolves relative destinations against the request
+	// path, so it must be relative to the prefix as well.
+	r.URL = h.relative(r.URL)
+	http.Redirect(w, r, h.destination(r.URL), h.Status)
+}
+
+// relative returns a copy of the request URL u relative to the handler's
+// prefix.
+func (h *RedirectHandler) relative(u *url.URL) *url.URL {
+	ref := *u
+
 	// drop scheme and host to ensure URL is relative
-	r.URL.Scheme = ""
-	r.URL.Host = ""
+	ref.Scheme = ""
+	ref.Host = ""
 
 	// trim path prefix
-	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/"+h.Prefix)
-	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/")
+	ref.Path = strings.TrimPrefix(ref.Path, "/"+h.Prefix)
+	ref.Path = strings.TrimPrefix(ref.Path, "/")
+	return &ref
+}
+
+// destination returns the URL that requests are redirected to, given the
+// request URL ref relative to the handler's prefix.
+func (h *RedirectHandler) destination(ref *url.URL) string {
+	if h.Query == "" {
+		return h.Destination.ResolveReference(ref).String()
+	}
+
+	// resolve the path, and then apply the query policy to the query of
+	// the destination and request.
+	path := *ref
+	path.RawQuery = ""
+	dest := h.Destination.ResolveReference(&path)
+	dest.RawQuery = h.Destination.RawQuery
+	return h.Query.redirectURL(dest.String(), ref.RawQuery, QueryPreserve)
+}
 
-	dest := h.Destination.ResolveReference(r.URL)
-	http.Redirect(w, r, dest.String(), h.Status)
+// preview implements previewer.
+func (h *RedirectHandler) preview(r *http.Request) (preview, bool) {
+	return preview{
+		Destination: h.destination(h.relative(r.URL)),
+		Status:      h.Status,
+		Source:      "redirect " + h.Host + "/" + h.Prefix,
+	}, true
 }
 
 // Register this handler with the provided ServeMux.
 func (h *RedirectHandler) Register(mux *http.ServeMux) error {
-	log.Printf("New redirect handler: %v => %v", h.Prefix, h.Destination)
-	mux.Handle("/"+h.Prefix, h)
-	mux.Handle("/"+h.Prefix+"/", h)
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	log.Printf("New redirect handler: %v => %v", h.Host+"/"+h.Prefix, h.Destination)
+
+	// ServeMux matches hosts case sensitively, and request hosts are
+	// typically lower case.
+	host := strings.ToLower(h.Host)
+	mux.Handle(host+"/"+h.Prefix, h)
+	mux.Handle(host+"/"+h.Prefix+"/", h)
 	return nil
 }
 
@@ -102,6 +102,21 @@ func TestRedirectHandler(t *testing.T) {
 			in: "/x/y", out: "http://example/x/y",
 		},
 
+		// destination URL with query, which is only used for the
+		// prefix itself unless a query policy is set
+		{
+			prefix: "x", dest: "http://example/?s=1",
+			in: "/x", out: "http://example/?s=1",
+		},
+		{
+			prefix: "x", dest: "http://example/?s=1",
+			in: "/x/y", out: "http://example/y",
+		},
+		{
+			prefix: "x", dest: "http://example/?s=1",
+			in: "/x/y?a=b", out: "http://example/y?a=b",
+		},
+
 		// no destination (redirects to root)
 		{prefix: "x", dest: "", in: "/x", out: "/"},
 		{prefix: "x", dest: "", in: "/x/", out: "/"},
@@ -173,3 +188,53 @@ func TestRedirectHandler_Register(t *testing.T) {
 		}
 	}
 }
+
+// Test that RedirectHandlers for different hosts can share a prefix, and that
+// hosts are matched without regard to case.
+func TestRedirectHandler_Host(t *testing.T) {
+	mux := http.NewServeMux()
+	for host, dest := range map[string]string{
+		"":          "/any/",
+		"a.example": "/a/",
+		"B.example": "/b/",
+	} {
+		handler, err := NewRedirectHandler("x", dest)
+		if err != nil {
+			t.Fatalf("error constructing handler: %v", err)
+		}
+		handler.Host = host
+		handler.Register(mux)
+	}
+
+	tests := []struct {
+		in, location string
+	}{
+		{"http://a.example/x/y", "/a/y"},
+		{"http://b.example/x/y", "/b/y"},
+		{"http://c.example/x/y", "/any/y"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.in, nil)
+		if err != nil {
+			t.Errorf("error constructing request for %q: %v", tt.in, err)
+		}
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("response Location header for %q was %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestRedirectHandler_Register_Invalid(t *testing.T) {
+	handler, err := NewRedirectHandler("/x", "/dest/")
+	if err != nil {
+		t.Fatalf("error constructing handler: %v", err)
+	}
+	if err := handler.Register(http.NewServeMux()); err == nil {
+		t.Errorf("Register with invalid prefix did not return expected error")
+	}
+}
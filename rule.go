@@ -0,0 +1,262 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Rule redirects request paths matching a pattern to a destination built
+// from a template.  Patterns take one of two forms:
+//
+// A path pattern consists of literal text and named parameters in braces.  A
+// parameter matches a single path segment, or the regular expression given
+// after a colon.  For example:
+//
+//     /i/{id:[0-9]+}
+//     /u/{user}/{path:.*}
+//
+// A regular expression pattern begins with "^", and is matched against the
+// request path.  Parameters are the named and numbered capture groups of the
+// expression.  For example:
+//
+//     ^/i/([0-9]+)$
+//     ^/i/(?P<id>[0-9]+)$
+//
+// The destination template refers to parameters by name or number in braces,
+// such as "https://github.com/org/repo/issues/{id}".  Patterns are matched
+// against the escaped request path, and parameter values are substituted
+// without further escaping.
+type Rule struct {
+	// Pattern is the path pattern or regular expression of the rule.
+	Pattern string
+
+	// Destination is the destination URL template of the rule.
+	Destination string
+
+	// Status is the HTTP status to return in redirect responses.
+	Status int
+
+	// Query is the policy for handling the query string of requests.  By
+	// default, request parameters are merged into the destination query.
+	Query QueryPolicy
+
+	re *regexp.Regexp
+}
+
+// templateParam matches parameters in patterns and destination templates.
+var templateParam = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// NewRule constructs a new Rule with the specified pattern and destination
+// template, and a 301 (Moved Permanently) response status.
+func NewRule(pattern, destination string) (*Rule, error) {
+	r := &Rule{
+		Pattern:     pattern,
+		Destination: destination,
+		Status:      http.StatusMovedPermanently,
+	}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// compile compiles the pattern of r and checks that the destination template
+// only refers to parameters of the pattern.
+func (r *Rule) compile() error {
+	var expr string
+	if strings.HasPrefix(r.Pattern, "^") {
+		expr = r.Pattern
+	} else {
+		if !strings.HasPrefix(r.Pattern, "/") {
+			return fmt.Errorf("gum: rule pattern %q should begin with a slash or '^'", r.Pattern)
+		}
+		var err error
+		if expr, err = patternRegexp(r.Pattern); err != nil {
+			return err
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("gum: invalid rule pattern %q: %w", r.Pattern, err)
+	}
+	for _, match := range templateParam.FindAllStringSubmatch(r.Destination, -1) {
+		if !hasParam(re, match[1]) {
+			return fmt.Errorf("gum: rule destination %q refers to unknown parameter %q", r.Destination, match[1])
+		}
+	}
+	r.re = re
+	return nil
+}
+
+// patternRegexp returns the regular expression for the path pattern p.
+func patternRegexp(p string) (string, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range templateParam.FindAllStringSubmatchIndex(p, -1) {
+		expr.WriteString(regexp.QuoteMeta(p[last:loc[0]]))
+		last = loc[1]
+
+		param := p[loc[2]:loc[3]]
+		name, sub := param, "[^/]+"
+		if i := strings.Index(param, ":"); i >= 0 {
+			name, sub = param[:i], param[i+1:]
+		}
+		if name == "" || sub == "" {
+			return "", fmt.Errorf("gum: invalid parameter %q in rule pattern %q", param, p)
+		}
+		fmt.Fprintf(&expr, "(?P<%s>%s)", name, sub)
+	}
+	expr.WriteString(regexp.QuoteMeta(p[last:]))
+	expr.WriteString("$")
+	return expr.String(), nil
+}
+
+// hasParam reports whether re has a capture group with the specified name or
+// number.
+func hasParam(re *regexp.Regexp, param string) bool {
+	if n, err := strconv.Atoi(param); err == nil {
+		return n >= 0 && n <= re.NumSubexp()
+	}
+	return param != "" && re.SubexpIndex(param) >= 0
+}
+
+// validate returns an error if r is not a valid rule.
+func (r *Rule) validate() error {
+	if r.re == nil {
+		if err := r.compile(); err != nil {
+			return err
+		}
+	}
+	if !isRedirectStatus(r.Status) {
+		return fmt.Errorf("gum: status %d is not a valid redirect status", r.Status)
+	}
+	return r.Query.Validate()
+}
+
+// expand returns the destination for path, or false if path does not match
+// the rule.
+func (r *Rule) expand(path string) (string, bool) {
+	match := r.re.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	dest := templateParam.ReplaceAllStringFunc(r.Destination, func(s string) string {
+		param := s[1 : len(s)-1]
+		if n, err := strconv.Atoi(param); err == nil {
+			return match[n]
+		}
+		return match[r.re.SubexpIndex(param)]
+	})
+	return dest, true
+}
+
+// muxPattern returns the ServeMux pattern which covers all paths the rule
+// can match: the literal prefix of the pattern up to its last slash, or the
+// whole pattern if it has no parameters.
+func (r *Rule) muxPattern() string {
+	prefix, complete := r.re.LiteralPrefix()
+	if complete && strings.HasPrefix(prefix, "/") {
+		return prefix
+	}
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return prefix[:i+1]
+	}
+	return "/"
+}
+
+// RuleHandler redirects requests using a list of rules, which are evaluated in
+// order.  Requests are redirected by the first rule whose pattern matches the
+// request path.  If no rule matches, the request is served by NotFound.
+type RuleHandler struct {
+	// Host is the optional host this handler should handle.  If empty,
+	// requests for all hosts are handled.
+	Host string
+
+	// Rules are the rules of the handler, in order of evaluation.
+	Rules []*Rule
+}
+
+// NewRuleHandler constructs a new RuleHandler with the specified rules.
+func NewRuleHandler(rules ...*Rule) *RuleHandler {
+	return &RuleHandler{Rules: rules}
+}
+
+// validate returns an error if h is not a valid rule handler.
+func (h *RuleHandler) validate() error {
+	if strings.Contains(h.Host, "/") {
+		return fmt.Errorf("gum: invalid host %q", h.Host)
+	}
+	for _, r := range h.Rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *RuleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rule, dest, ok := h.match(r.URL)
+	if !ok {
+		NotFound(w, r)
+		return
+	}
+	metrics.redirects.inc("handler", "rule", "source", h.Host+rule.Pattern)
+	setAccessEntry(r, "rule", h.Host+rule.Pattern)
+	http.Redirect(w, r, dest, rule.Status)
+}
+
+// match returns the first rule which matches the path of u, and the URL that
+// requests for u are redirected to.
+func (h *RuleHandler) match(u *url.URL) (*Rule, string, bool) {
+	for _, rule := range h.Rules {
+		if dest, ok := rule.expand(u.EscapedPath()); ok {
+			return rule, rule.Query.redirectURL(dest, u.RawQuery, QueryMerge), true
+		}
+	}
+	return nil, "", false
+}
+
+// preview implements previewer.
+func (h *RuleHandler) preview(r *http.Request) (preview, bool) {
+	rule, dest, ok := h.match(r.URL)
+	if !ok {
+		return preview{}, false
+	}
+	return preview{
+		Destination: dest,
+		Status:      rule.Status,
+		Source:      "rule " + h.Host + rule.Pattern,
+	}, true
+}
+
+// Register this handler with the provided ServeMux.
+func (h *RuleHandler) Register(mux *http.ServeMux) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	registered := make(map[string]bool)
+	for _, rule := range h.Rules {
+		log.Printf("New redirect rule: %v => %v", h.Host+rule.Pattern, rule.Destination)
+		if pattern := h.Host + rule.muxPattern(); !registered[pattern] {
+			mux.Handle(pattern, h)
+			registered[pattern] = true
+		}
+	}
+	return nil
+}
+
+// Mappings implements the Handler interface.
+func (h *RuleHandler) Mappings(mappings chan<- Mapping) error { return nil }
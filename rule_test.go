@@ -0,0 +1,192 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRule(t *testing.T) {
+	tests := []struct {
+		pattern, dest string
+		in, out       string // empty out means no match
+	}{
+		// path patterns
+		{
+			pattern: "/i/{id:[0-9]+}", dest: "https://github.com/org/repo/issues/{id}",
+			in: "/i/123", out: "https://github.com/org/repo/issues/123",
+		},
+		{
+			pattern: "/i/{id:[0-9]+}", dest: "https://github.com/org/repo/issues/{id}",
+			in: "/i/abc", out: "",
+		},
+		{
+			pattern: "/i/{id:[0-9]+}", dest: "https://github.com/org/repo/issues/{id}",
+			in: "/i/123/x", out: "",
+		},
+		{
+			pattern: "/u/{user}", dest: "https://example/~{user}",
+			in: "/u/bob", out: "https://example/~bob",
+		},
+		{
+			pattern: "/u/{user}", dest: "https://example/~{user}",
+			in: "/u/bob/x", out: "",
+		},
+		{
+			pattern: "/u/{user}/{path:.*}", dest: "https://{user}.example/{path}",
+			in: "/u/bob/a/b", out: "https://bob.example/a/b",
+		},
+		{
+			pattern: "/x.{ext}", dest: "/files/x.{ext}",
+			in: "/x.txt", out: "/files/x.txt",
+		},
+		{
+			pattern: "/x.{ext}", dest: "/files/x.{ext}",
+			in: "/xytxt", out: "",
+		},
+
+		// escaped paths
+		{
+			pattern: "/s/{q}", dest: "https://search/?q={q}",
+			in: "/s/a%20b", out: "https://search/?q=a%20b",
+		},
+
+		// regular expressions
+		{
+			pattern: "^/i/([0-9]+)$", dest: "/issues/{1}",
+			in: "/i/42", out: "/issues/42",
+		},
+		{
+			pattern: "^/i/(?P<id>[0-9]+)$", dest: "/issues/{id}?from={0}",
+			in: "/i/42", out: "/issues/42?from=/i/42",
+		},
+		{
+			pattern: "^/i/([0-9]+)$", dest: "/issues/{1}",
+			in: "/i/x", out: "",
+		},
+	}
+
+	for _, tt := range tests {
+		rule, err := NewRule(tt.pattern, tt.dest)
+		if err != nil {
+			t.Fatalf("NewRule(%q, %q) returned error: %v", tt.pattern, tt.dest, err)
+		}
+
+		r, err := http.NewRequest("GET", tt.in, nil)
+		if err != nil {
+			t.Fatalf("error constructing request: %v", err)
+		}
+		got, ok := rule.expand(r.URL.EscapedPath())
+		if ok != (tt.out != "") || got != tt.out {
+			t.Errorf("rule %q => %q expanded %q to %q, %v, want %q", tt.pattern, tt.dest, tt.in, got, ok, tt.out)
+		}
+	}
+}
+
+func TestNewRule_Errors(t *testing.T) {
+	tests := []struct {
+		pattern, dest string
+	}{
+		{"i/{id}", "/{id}"},
+		{"/i/{id}", "/{other}"},
+		{"/i/{id:[0-9}", "/{id}"},
+		{"/i/{:[0-9]+}", "/"},
+		{"/i/{id:}", "/"},
+		{"^/i/(", "/"},
+		{"^/i/([0-9]+)$", "/{2}"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewRule(tt.pattern, tt.dest); err == nil {
+			t.Errorf("NewRule(%q, %q) did not return expected error", tt.pattern, tt.dest)
+		}
+	}
+}
+
+func TestRuleHandler(t *testing.T) {
+	rules := []struct {
+		pattern, dest string
+		status        int
+	}{
+		{"/i/{id:[0-9]+}", "https://github.com/org/repo/issues/{id}", http.StatusMovedPermanently},
+		{"/i/{name}", "https://github.com/org/{name}", http.StatusFound},
+		{"/gh", "https://github.com/org", http.StatusMovedPermanently},
+		{"^/p([0-9]+)$", "/posts/{1}", http.StatusMovedPermanently},
+	}
+	h := NewRuleHandler()
+	for _, r := range rules {
+		rule, err := NewRule(r.pattern, r.dest)
+		if err != nil {
+			t.Fatalf("NewRule(%q, %q) returned error: %v", r.pattern, r.dest, err)
+		}
+		rule.Status = r.status
+		h.Rules = append(h.Rules, rule)
+	}
+	mux := http.NewServeMux()
+	if err := h.Register(mux); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+
+	tests := []struct {
+		in, location string
+		code         int
+	}{
+		// rules are evaluated in order
+		{"/i/123", "https://github.com/org/repo/issues/123", http.StatusMovedPermanently},
+		{"/i/gum", "https://github.com/org/gum", http.StatusFound},
+		{"/i/123?a=b", "https://github.com/org/repo/issues/123?a=b", http.StatusMovedPermanently},
+		{"/gh", "https://github.com/org", http.StatusMovedPermanently},
+		{"/p12", "/posts/12", http.StatusMovedPermanently},
+
+		// unmatched paths
+		{"/i/123/x", "", http.StatusNotFound},
+		{"/gh/x", "", http.StatusNotFound},
+		{"/px", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.in, nil)
+		if err != nil {
+			t.Fatalf("error constructing request for %q: %v", tt.in, err)
+		}
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("response for %q had status %v, want %v", tt.in, got, want)
+		}
+		if got, want := resp.Header().Get("Location"), tt.location; got != want {
+			t.Errorf("response Location header for %q was %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestRule_MuxPattern(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{"/i/{id}", "/i/"},
+		{"/i{id}", "/"},
+		{"/a/b/x.{ext}", "/a/b/"},
+		{"/gh", "/gh"},
+		{"^/i/([0-9]+)$", "/i/"},
+		{"^/gh$", "/gh"},
+		{"^(/x)", "/"},
+	}
+
+	for _, tt := range tests {
+		rule, err := NewRule(tt.pattern, "/")
+		if err != nil {
+			t.Fatalf("NewRule(%q) returned error: %v", tt.pattern, err)
+		}
+		if got := rule.muxPattern(); got != tt.want {
+			t.Errorf("muxPattern for %q returned %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
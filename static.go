@@ -14,7 +14,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -25,14 +28,70 @@ const (
 	relShortlink = "shortlink"
 	relCanonical = "canonical"
 	attrAltHref  = "data-alt-href"
+	attrQuery    = "data-query"
+	attrStatus   = "data-status"
 )
 
+// publishedTimeFormats are the formats publication dates are parsed with.
+var publishedTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
 // StaticHandler handles short URLs parsed from static HTML files.  Files are
 // parsed and searched for rel="shortlink" and rel="canonical" links.  If both
-// are found, a redirect is registered for the pair.
+// are found, a redirect is registered for the pair.  If multiple files specify
+// the same shortlink, the most recently modified file is used.  The query
+// policy of a file's mappings can be specified with a data-query attribute on
+// the rel="shortlink" link, such as data-query="merge utm_source=short", and
+// the redirect status with a data-status attribute, such as data-status="302"
+// or data-status="410" for retired shortlinks.
+//
+// If Whistle is set, shortlinks are also computed for each file from its
+// publication date, which is read from a <time class="dt-published"> element
+// or an article:published_time or date <meta> element.  Shortlinks listed in
+// a file take precedence over computed ones.
+//
+// If Tombstones is set, the shortlinks listed in a file are retired when the
+// file is deleted, and respond with a 410 (Gone) status rather than 404 (Not
+// Found).  See SourceTombstone.
 type StaticHandler struct {
+	// MatchHost specifies whether mappings should be restricted to the
+	// host of their shortlink URL.  By default, the host is ignored and
+	// mappings apply to requests for any host.
+	MatchHost bool
+
+	// Whistle, if set, is used to compute shortlinks for each file.
+	Whistle *Whistle
+
+	// Query is the query policy of mappings which do not specify their
+	// own with a data-query attribute on the rel="shortlink" link.
+	Query QueryPolicy
+
+	// Tombstones specifies whether tombstones are recorded for the
+	// shortlinks of deleted files.
+	Tombstones bool
+
 	base    string
 	watcher *fsnotify.Watcher
+	// closed when the watcher goroutine exits
+	done chan struct{}
+
+	// mutex guards the fields below
+	mutex sync.Mutex
+	// map of HTML file paths to the mappings parsed from them.  Every HTML
+	// file found under base has an entry, even if it contains no mappings.
+	files map[string][]Mapping
+	// map of HTML file paths to the pages parsed from them
+	pages map[string]page
+	// mappings computed with Whistle
+	computed []Mapping
+	// set of directories currently being watched
+	dirs map[string]bool
+	// first error reported by the watcher, if any
+	watchErr error
 }
 
 // NewStaticHandler constructs a new StaticHandler with the specified base path
@@ -44,12 +103,22 @@ func NewStaticHandler(base string) (*StaticHandler, error) {
 		return nil, fmt.Errorf("Specified base path %q is not a directory", base)
 	}
 
-	return &StaticHandler{base: base}, nil
+	return &StaticHandler{
+		base:  base,
+		files: make(map[string][]Mapping),
+		pages: make(map[string]page),
+		dirs:  make(map[string]bool),
+	}, nil
 }
 
 // Mappings implements Handler.
 func (h *StaticHandler) Mappings(mappings chan<- Mapping) error {
-	if err := loadFiles(h.base, mappings); err != nil {
+	if h.Whistle != nil {
+		if err := h.Whistle.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := h.loadFiles(h.base, mappings); err != nil {
 		return err
 	}
 
@@ -59,12 +128,29 @@ func (h *StaticHandler) Mappings(mappings chan<- Mapping) error {
 		return fmt.Errorf("error creating file watcher: %w", err)
 	}
 
+	// setup initial file watchers for h.base and all sub-directories
+	if err := h.watch(h.base); err != nil {
+		h.watcher.Close()
+		h.watcher = nil
+		return fmt.Errorf("error setting up watchers for %q: %w", h.base, err)
+	}
+
+	h.done = make(chan struct{})
 	go func() {
+		defer close(h.done)
 		for {
 			select {
-			case ev := <-h.watcher.Events:
+			case ev, ok := <-h.watcher.Events:
+				if !ok {
+					// watcher was closed
+					return
+				}
+
 				if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
-					// ignore Remove and Rename events
+					// the file or directory at ev.Name no longer exists
+					// (renamed files are reported as a separate Create
+					// event for their new name)
+					h.removeFiles(ev.Name, mappings)
 					continue
 				}
 
@@ -74,43 +160,82 @@ func (h *StaticHandler) Mappings(mappings chan<- Mapping) error {
 					continue
 				}
 
-				// add watcher for newly created directories
+				// add watchers for newly created directories
 				if ev.Op&fsnotify.Create == fsnotify.Create && stat.IsDir() {
-					h.watcher.Add(ev.Name)
+					if err := h.watch(ev.Name); err != nil {
+						log.Print(err)
+					}
 				}
 
 				// if event is Create or Write, reload files
 				if ev.Op&(fsnotify.Create|fsnotify.Write) != 0 {
-					if err := loadFiles(ev.Name, mappings); err != nil {
+					if err := h.loadFiles(ev.Name, mappings); err != nil {
 						log.Print(err)
 					}
 				}
-			case err := <-h.watcher.Errors:
+			case err, ok := <-h.watcher.Errors:
+				if !ok {
+					return
+				}
+				metrics.watcherErrors.inc()
 				log.Printf("Watcher error: %v", err)
+				h.mutex.Lock()
+				if h.watchErr == nil {
+					h.watchErr = err
+				}
+				h.mutex.Unlock()
 			}
 		}
 	}()
+	return nil
+}
 
-	// setup initial file watchers for h.base and all sub-directories
-	err = filepath.Walk(h.base, func(path string, info os.FileInfo, err error) error {
+// Register is a noop for this handler.
+func (h *StaticHandler) Register(mux *http.ServeMux) error { return nil }
+
+// Close stops watching for file changes.  No further mappings will be written
+// once Close returns.
+func (h *StaticHandler) Close() error {
+	if h.watcher == nil {
+		return nil
+	}
+	err := h.watcher.Close()
+	<-h.done
+	return err
+}
+
+// Healthy implements HealthChecker.  Once the file watcher has reported an
+// error, such as an event queue overflow, changes to files may have been
+// missed, so the handler is unhealthy until it is replaced, such as by
+// reloading the server.
+func (h *StaticHandler) Healthy() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.watchErr != nil {
+		return fmt.Errorf("watcher error for %q: %w", h.base, h.watchErr)
+	}
+	return nil
+}
+
+// watch adds file watchers for base and all of its sub-directories.
+func (h *StaticHandler) watch(base string) error {
+	return filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
 		if err == nil && info.IsDir() {
-			err = h.watcher.Add(path)
-			if err != nil {
+			if err := h.watcher.Add(path); err != nil {
 				return fmt.Errorf("error watching path %q: %w", path, err)
 			}
+			h.mutex.Lock()
+			h.dirs[path] = true
+			h.mutex.Unlock()
 		}
 		return nil
 	})
-	if err != nil {
-		return fmt.Errorf("error setting up watchers for %q: %w", h.base, err)
-	}
-	return nil
 }
 
-// Register is a noop for this handler.
-func (h *StaticHandler) Register(mux *http.ServeMux) error { return nil }
-
-func loadFiles(base string, mappings chan<- Mapping) error {
+// loadFiles parses all HTML files under base, recording the mappings found
+// in each file.  Any changes from the mappings previously loaded from each file
+// are written to mappings.
+func (h *StaticHandler) loadFiles(base string, mappings chan<- Mapping) error {
 	walkFn := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -126,82 +251,313 @@ func loadFiles(base string, mappings chan<- Mapping) error {
 		}
 		defer f.Close()
 
-		fileMappings, err := parseFile(f)
+		p, err := parsePage(f)
 		if err != nil {
+			metrics.parseErrors.inc()
 			return fmt.Errorf("error parsing file %q: %w", path, err)
 		}
+		fileMappings := p.mappings()
+		source := Source{
+			Kind:     SourceStatic,
+			Name:     h.base,
+			File:     path,
+			Modified: info.ModTime(),
+		}
+		for i := range fileMappings {
+			fileMappings[i].Source = source
+			if !h.MatchHost {
+				fileMappings[i].Host = ""
+			}
+			if fileMappings[i].Query == "" {
+				fileMappings[i].Query = h.Query
+			}
+			if err := fileMappings[i].Query.Validate(); err != nil {
+				log.Printf("Ignoring query policy in %q: %v", path, err)
+				fileMappings[i].Query = h.Query
+			}
+		}
 
-		for _, m := range fileMappings {
+		h.mutex.Lock()
+		previous := h.files[path]
+		h.files[path] = fileMappings
+		h.pages[path] = p
+		h.mutex.Unlock()
+
+		for _, m := range diffMappings(previous, fileMappings) {
 			mappings <- m
 		}
 		return nil
 	}
 
 	err := filepath.Walk(base, walkFn)
+	h.updateWhistle(mappings)
 	if err != nil {
 		return fmt.Errorf("error walking %q: %w", base, err)
 	}
 	return nil
 }
 
+// updateWhistle computes the Whistle mappings for all loaded files, and writes
+// any changes from those previously computed to mappings.  Since the ordinal of
+// a file's shortlink depends on the other files published the same day, all
+// mappings are computed again whenever any file changes.
+func (h *StaticHandler) updateWhistle(mappings chan<- Mapping) {
+	if h.Whistle == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	computed := h.Whistle.mappings(h.pages, func(file string) Source {
+		// computed mappings use a distinct source from those listed
+		// in the file, and have no modification time so that listed
+		// mappings take precedence.
+		return Source{Kind: SourceStatic, Name: h.base + " (whistle)", File: file}
+	})
+	for i := range computed {
+		computed[i].Query = h.Query
+	}
+	previous := h.computed
+	h.computed = computed
+	h.mutex.Unlock()
+
+	for _, m := range diffMappings(previous, computed) {
+		mappings <- m
+	}
+}
+
+// removeFiles removes the file at path, or if path was a directory, all files
+// under it.  A deletion mapping is written to mappings for each mapping that
+// was previously loaded from the removed files, preceded by a tombstone if
+// h.Tombstones is set.
+func (h *StaticHandler) removeFiles(path string, mappings chan<- Mapping) {
+	var removed []Mapping
+
+	h.mutex.Lock()
+	for file, fileMappings := range h.files {
+		if inPath(file, path) {
+			removed = append(removed, fileMappings...)
+			delete(h.files, file)
+			delete(h.pages, file)
+		}
+	}
+	for dir := range h.dirs {
+		if inPath(dir, path) {
+			// watches on removed directories are dropped automatically,
+			// but renamed directories need to be removed explicitly.
+			h.watcher.Remove(dir)
+			delete(h.dirs, dir)
+		}
+	}
+	h.mutex.Unlock()
+
+	now := time.Now()
+	for _, m := range removed {
+		if h.Tombstones {
+			// tombstones are written first so that the short URL
+			// is never briefly unmapped.
+			mappings <- m.tombstone(now)
+		}
+		mappings <- Mapping{Host: m.Host, ShortPath: m.ShortPath, Source: m.Source}
+	}
+	h.updateWhistle(mappings)
+}
+
+// diffMappings returns the changes needed to go from the old to the new set of
+// mappings for a file: a deletion mapping for each short URL no longer
+// present, followed by each mapping that is new or has a changed permalink.
+func diffMappings(old, new []Mapping) []Mapping {
+	var changes []Mapping
+
+	current := make(map[string]bool)
+	for _, m := range new {
+		current[m.key()] = true
+	}
+	for _, m := range old {
+		if !current[m.key()] {
+			changes = append(changes, Mapping{Host: m.Host, ShortPath: m.ShortPath, Source: m.Source})
+			// prevent duplicate deletions
+			current[m.key()] = true
+		}
+	}
+
+	previous := make(map[Mapping]bool)
+	for _, m := range old {
+		previous[m] = true
+	}
+	for _, m := range new {
+		if !previous[m] {
+			changes = append(changes, m)
+		}
+	}
+
+	return changes
+}
+
+// inPath reports whether file is equal to or located under the directory path.
+func inPath(file, path string) bool {
+	return file == path || strings.HasPrefix(file, path+string(filepath.Separator))
+}
+
+// page describes the links and metadata parsed from an HTML file.
+type page struct {
+	// permalink is the URL of the first rel="canonical" link
+	permalink string
+
+	// shortlinks are the URLs of all rel="shortlink" links, including
+	// their alternates
+	shortlinks []string
+
+	// published is the publication time of the page, if specified
+	published time.Time
+
+	// query is the query policy specified on the rel="shortlink" link
+	query QueryPolicy
+
+	// status is the redirect status specified on the rel="shortlink" link
+	status int
+
+	// title is the text of the page's title element
+	title string
+}
+
+// mappings returns a mapping from each of the page's shortlinks to its
+// permalink.  Returned mappings include the host of the shortlink URL, if
+// present.
+func (p page) mappings() []Mapping {
+	if p.permalink == "" {
+		return nil
+	}
+	var mappings []Mapping
+	for _, link := range p.shortlinks {
+		shorturl, err := url.Parse(link)
+		if err != nil {
+			log.Printf("error parsing shortlink %q: %v", link, err)
+			continue
+		}
+		if path := shorturl.Path; len(path) > 1 {
+			mappings = append(mappings, Mapping{
+				Host:      strings.ToLower(shorturl.Host),
+				ShortPath: path,
+				Permalink: p.permalink,
+				Query:     p.query,
+				Status:    p.status,
+				Title:     p.title,
+			})
+		}
+	}
+	return mappings
+}
+
 // parseFile parses r as HTML and returns the URLs of the first links found
-// with the "shortlink" and "canonical" rel values.
-func parseFile(r io.Reader) (mappings []Mapping, err error) {
-	var permalink string
-	var shortlinks []string
+// with the "shortlink" and "canonical" rel values.  Returned mappings include
+// the host of the shortlink URL, if present.
+func parseFile(r io.Reader) ([]Mapping, error) {
+	p, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.mappings(), nil
+}
 
+// parsePage parses r as HTML, returning its canonical URL, shortlinks, title,
+// and publication time.
+func parsePage(r io.Reader) (p page, err error) {
 	doc, err := html.Parse(r)
 	if err != nil {
-		return nil, err
+		return p, err
 	}
 
+	var published string
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
-			if n.DataAtom == atom.Link || n.DataAtom == atom.A {
-				var href, rel, altHref string
-				for _, a := range n.Attr {
-					if a.Key == atom.Href.String() {
-						href = a.Val
-					}
-					if a.Key == atom.Rel.String() {
-						rel = a.Val
-					}
-					if a.Key == attrAltHref {
-						altHref = a.Val
-					}
-				}
+			switch n.DataAtom {
+			case atom.Link, atom.A:
+				href, rel := attr(n, atom.Href.String()), attr(n, atom.Rel.String())
 				if href != "" && rel != "" {
-					for _, v := range strings.Split(rel, " ") {
+					for _, v := range strings.Fields(rel) {
 						if v == relShortlink {
-							shortlinks = append(shortlinks, href)
-							shortlinks = append(shortlinks, strings.Split(altHref, " ")...)
+							p.shortlinks = append(p.shortlinks, href)
+							p.shortlinks = append(p.shortlinks, strings.Fields(attr(n, attrAltHref))...)
+							if q := attr(n, attrQuery); q != "" {
+								p.query = QueryPolicy(q)
+							}
+							if s := attr(n, attrStatus); s != "" {
+								p.status = parseStatus(s)
+							}
 						}
-						if v == relCanonical && permalink == "" {
-							permalink = href
+						if v == relCanonical && p.permalink == "" {
+							p.permalink = href
 						}
 					}
 				}
+			case atom.Meta:
+				if attr(n, "property") == "article:published_time" || attr(n, "name") == "date" {
+					if published == "" {
+						published = attr(n, "content")
+					}
+				}
+			case atom.Title:
+				if p.title == "" && n.Namespace == "" {
+					p.title = strings.Join(strings.Fields(text(n)), " ")
+				}
+			case atom.Time:
+				for _, class := range strings.Fields(attr(n, "class")) {
+					if class == "dt-published" && published == "" {
+						published = attr(n, "datetime")
+					}
+				}
 			}
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			f(c)
 		}
 	}
-
 	f(doc)
 
-	if len(shortlinks) > 0 && permalink != "" {
-		for _, link := range shortlinks {
-			shorturl, err := url.Parse(link)
-			if err != nil {
-				log.Printf("error parsing shortlink %q: %v", link, err)
-			}
-			if path := shorturl.Path; len(path) > 1 {
-				mappings = append(mappings, Mapping{ShortPath: path, Permalink: permalink})
+	if published != "" {
+		for _, layout := range publishedTimeFormats {
+			if t, err := time.Parse(layout, published); err == nil {
+				p.published = t
+				break
 			}
 		}
+		if p.published.IsZero() {
+			log.Printf("error parsing publication time %q", published)
+		}
 	}
+	return p, nil
+}
 
-	return mappings, nil
+// parseStatus parses the redirect status s, returning zero if it is not a
+// valid redirect status or 410 (Gone).
+func parseStatus(s string) int {
+	status, err := strconv.Atoi(s)
+	if err != nil || status != http.StatusGone && !isRedirectStatus(status) {
+		log.Printf("Ignoring invalid shortlink status %q", s)
+		return 0
+	}
+	return status
+}
+
+// text returns the text content of n and its descendants.
+func text(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(text(c))
+	}
+	return b.String()
+}
+
+// attr returns the value of the attribute of n with the specified key.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
 }
@@ -8,7 +8,13 @@ package gum
 
 import (
 	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestParseFile(t *testing.T) {
@@ -33,3 +39,238 @@ func TestParseFile(t *testing.T) {
 		t.Fatalf("parseFile(%q) returned mapping %v, want %v", input, got, want)
 	}
 }
+
+func TestParseFile_Host(t *testing.T) {
+	input := `<link rel="shortlink" href="http://X.example/s1" data-alt-href="/s2"><link rel="canonical" href="/p">`
+
+	mappings, err := parseFile(bytes.NewBufferString(input))
+	if err != nil {
+		t.Fatalf("error parsing file: %v", err)
+	}
+
+	want := []Mapping{
+		{Host: "x.example", ShortPath: "/s1", Permalink: "/p"},
+		{ShortPath: "/s2", Permalink: "/p"},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("parseFile(%q) returned mappings %v, want %v", input, mappings, want)
+	}
+}
+
+// readMapping reads the next Mapping from mappings, failing the test if none
+// is received within a reasonable time.
+func readMapping(t *testing.T, mappings <-chan Mapping) Mapping {
+	t.Helper()
+	select {
+	case m := <-mappings:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for mapping")
+	}
+	return Mapping{}
+}
+
+func TestStaticHandler_Remove(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "posts")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(base, "a.html"): `<link rel="shortlink" href="/a"><link rel="canonical" href="/pa">`,
+		filepath.Join(dir, "b.html"):  `<link rel="shortlink" href="/b"><link rel="canonical" href="/pb">`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := NewStaticHandler(base)
+	if err != nil {
+		t.Fatalf("NewStaticHandler returned error: %v", err)
+	}
+	mappings := make(chan Mapping, 10)
+	if err := h.Mappings(mappings); err != nil {
+		t.Fatalf("Mappings returned error: %v", err)
+	}
+	defer h.Close()
+	for range files {
+		readMapping(t, mappings)
+	}
+
+	// removing a file deletes its mapping
+	if err := os.Remove(filepath.Join(base, "a.html")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMapping(t, mappings); got.ShortPath != "/a" || got.Permalink != "" {
+		t.Errorf("removing file sent mapping %v, want deletion of /a", got)
+	}
+
+	// removing a directory deletes the mappings of all files under it
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMapping(t, mappings); got.ShortPath != "/b" || got.Permalink != "" {
+		t.Errorf("removing directory sent mapping %v, want deletion of /b", got)
+	}
+}
+
+func TestStaticHandler_Tombstones(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "a.html")
+	content := `<link rel="shortlink" href="/a"><link rel="canonical" href="/pa">`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewStaticHandler(base)
+	if err != nil {
+		t.Fatalf("NewStaticHandler returned error: %v", err)
+	}
+	h.Tombstones = true
+	mappings := make(chan Mapping, 10)
+	if err := h.Mappings(mappings); err != nil {
+		t.Fatalf("Mappings returned error: %v", err)
+	}
+	defer h.Close()
+	readMapping(t, mappings)
+
+	// removing a file records a tombstone before deleting its mapping
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	got := readMapping(t, mappings)
+	if !got.isTombstone() || got.ShortPath != "/a" || got.Permalink != "/pa" || got.Status != http.StatusGone {
+		t.Errorf("removing file sent mapping %v, want tombstone for /a", got)
+	}
+	if got := readMapping(t, mappings); got.ShortPath != "/a" || !got.isDeletion() {
+		t.Errorf("removing file sent mapping %v, want deletion of /a", got)
+	}
+}
+
+func TestDiffMappings(t *testing.T) {
+	a1 := Mapping{ShortPath: "/a", Permalink: "/1"}
+	a2 := Mapping{ShortPath: "/a", Permalink: "/2"}
+	b1 := Mapping{ShortPath: "/b", Permalink: "/1"}
+	c1 := Mapping{ShortPath: "/c", Permalink: "/1"}
+
+	tests := []struct {
+		old, new []Mapping
+		want     []Mapping
+	}{
+		{nil, nil, nil},
+		{nil, []Mapping{a1, b1}, []Mapping{a1, b1}},
+		{[]Mapping{a1, b1}, []Mapping{a1, b1}, nil},
+		{[]Mapping{a1, b1}, nil, []Mapping{{ShortPath: "/a"}, {ShortPath: "/b"}}},
+
+		// changed permalink
+		{[]Mapping{a1}, []Mapping{a2}, []Mapping{a2}},
+
+		// removed and added alternate shortlinks
+		{[]Mapping{a1, b1}, []Mapping{a1, c1}, []Mapping{{ShortPath: "/b"}, c1}},
+	}
+
+	for _, tt := range tests {
+		if got := diffMappings(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diffMappings(%v, %v) returned %v, want %v", tt.old, tt.new, got, tt.want)
+		}
+	}
+}
+
+func TestStaticHandler_Write(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "a.html")
+	content := `<link rel="shortlink" href="/a" data-alt-href="/b"><link rel="canonical" href="/p">`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewStaticHandler(base)
+	if err != nil {
+		t.Fatalf("NewStaticHandler returned error: %v", err)
+	}
+	mappings := make(chan Mapping, 10)
+	if err := h.Mappings(mappings); err != nil {
+		t.Fatalf("Mappings returned error: %v", err)
+	}
+	defer h.Close()
+	readMapping(t, mappings)
+	readMapping(t, mappings)
+
+	// drop the alternate shortlink.  Write to a temp file and rename it
+	// into place so that the file is never seen partially written.
+	content = `<link rel="shortlink" href="/a"><link rel="canonical" href="/p">`
+	if err := ioutil.WriteFile(file+".tmp", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMapping(t, mappings); got.ShortPath != "/b" || got.Permalink != "" {
+		t.Errorf("editing file sent mapping %v, want deletion of /b", got)
+	}
+}
+
+func TestParsePage_Published(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{`<time class="h-entry dt-published" datetime="2014-02-21T10:00:00-08:00">`, time.Date(2014, 2, 21, 18, 0, 0, 0, time.UTC)},
+		{`<meta property="article:published_time" content="2014-02-21T10:00:00">`, time.Date(2014, 2, 21, 10, 0, 0, 0, time.UTC)},
+		{`<meta name="date" content="2014-02-21">`, time.Date(2014, 2, 21, 0, 0, 0, 0, time.UTC)},
+		{`<meta name="date" content="2014-02-21"><meta name="date" content="2015-01-01">`, time.Date(2014, 2, 21, 0, 0, 0, 0, time.UTC)},
+		{`<meta name="date" content="yesterday">`, time.Time{}},
+		{`<time datetime="2014-02-21">`, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		p, err := parsePage(bytes.NewBufferString(tt.input))
+		if err != nil {
+			t.Fatalf("parsePage(%q) returned error: %v", tt.input, err)
+		}
+		if !p.published.Equal(tt.want) {
+			t.Errorf("parsePage(%q) returned published %v, want %v", tt.input, p.published, tt.want)
+		}
+	}
+}
+
+func TestParseFile_Query(t *testing.T) {
+	input := `<link rel="shortlink" href="/s1" data-alt-href="/s2" data-query="merge"><link rel="canonical" href="/p">`
+
+	mappings, err := parseFile(bytes.NewBufferString(input))
+	if err != nil {
+		t.Fatalf("parseFile returned error: %v", err)
+	}
+	want := []Mapping{
+		{ShortPath: "/s1", Permalink: "/p", Query: QueryMerge},
+		{ShortPath: "/s2", Permalink: "/p", Query: QueryMerge},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("parseFile returned %v, want %v", mappings, want)
+	}
+}
+
+func TestParseFile_Status(t *testing.T) {
+	tests := []struct {
+		input  string
+		status int
+	}{
+		{`<link rel="shortlink" href="/s" data-status="302"><link rel="canonical" href="/p">`, 302},
+		{`<link rel="shortlink" href="/s" data-status="410"><link rel="canonical" href="/p">`, 410},
+		{`<link rel="shortlink" href="/s" data-status="200"><link rel="canonical" href="/p">`, 0},
+		{`<link rel="shortlink" href="/s" data-status="x"><link rel="canonical" href="/p">`, 0},
+		{`<link rel="shortlink" href="/s"><link rel="canonical" href="/p">`, 0},
+	}
+
+	for _, tt := range tests {
+		mappings, err := parseFile(bytes.NewBufferString(tt.input))
+		if err != nil {
+			t.Fatalf("parseFile(%q) returned error: %v", tt.input, err)
+		}
+		if len(mappings) != 1 || mappings[0].Status != tt.status {
+			t.Errorf("parseFile(%q) returned %v, want status %d", tt.input, mappings, tt.status)
+		}
+	}
+}
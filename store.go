@@ -0,0 +1,221 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A Store persists manual mappings, such as those created with the admin API,
+// so that they are not lost when the server restarts.  Mappings provided by
+// handlers are not stored, since they are loaded again when the handler is
+// added.
+type Store interface {
+	// Load returns all mappings in the store.
+	Load() ([]Mapping, error)
+
+	// Put adds m to the store, replacing any existing mapping for the same
+	// short URL.
+	Put(m Mapping) error
+
+	// Delete removes the mapping for the short URL with the specified host
+	// and path.  Deleting a mapping that does not exist is not an error.
+	Delete(host, shortPath string) error
+}
+
+// MemoryStore is a Store and ClickStore which keeps mappings and clicks in
+// memory.  It does not persist them across restarts, and is primarily useful
+// for testing.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	mappings map[string]Mapping
+	clicks   map[clickKey]uint64
+}
+
+// NewMemoryStore constructs a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mappings: make(map[string]Mapping),
+		clicks:   make(map[clickKey]uint64),
+	}
+}
+
+// Load implements Store.
+func (st *MemoryStore) Load() ([]Mapping, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	mappings := make([]Mapping, 0, len(st.mappings))
+	for _, m := range st.mappings {
+		mappings = append(mappings, m)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].key() < mappings[j].key()
+	})
+	return mappings, nil
+}
+
+// Put implements Store.
+func (st *MemoryStore) Put(m Mapping) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.mappings[m.key()] = m
+	return nil
+}
+
+// Delete implements Store.
+func (st *MemoryStore) Delete(host, shortPath string) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	delete(st.mappings, Mapping{Host: host, ShortPath: shortPath}.key())
+	return nil
+}
+
+// LoadClicks implements ClickStore.
+func (st *MemoryStore) LoadClicks() ([]Click, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	clicks := make([]Click, 0, len(st.clicks))
+	for k, n := range st.clicks {
+		clicks = append(clicks, k.click(n))
+	}
+	return clicks, nil
+}
+
+// AddClicks implements ClickStore.
+func (st *MemoryStore) AddClicks(clicks []Click) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	for _, c := range clicks {
+		st.clicks[c.key()] += c.Count
+	}
+	return nil
+}
+
+// Names of the bolt buckets mappings and clicks are stored in.
+var (
+	bucketMappings = []byte("mappings")
+	bucketClicks   = []byte("clicks")
+)
+
+// BoltStore is a Store and ClickStore which persists mappings and clicks in an
+// embedded bolt database file.  Mappings are stored as JSON, keyed by short
+// URL, and clicks are stored as JSON, keyed by day and short URL.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the bolt database at path, creating it if it does not
+// exist.  Only one process can have the database open at a time.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMappings, bucketClicks} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing store %q: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Load implements Store.
+func (st *BoltStore) Load() ([]Mapping, error) {
+	var mappings []Mapping
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMappings).ForEach(func(k, v []byte) error {
+			var m Mapping
+			if err := json.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("error decoding mapping %q: %w", k, err)
+			}
+			mappings = append(mappings, m)
+			return nil
+		})
+	})
+	return mappings, err
+}
+
+// Put implements Store.
+func (st *BoltStore) Put(m Mapping) error {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMappings).Put([]byte(m.key()), v)
+	})
+}
+
+// Delete implements Store.
+func (st *BoltStore) Delete(host, shortPath string) error {
+	key := Mapping{Host: host, ShortPath: shortPath}.key()
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMappings).Delete([]byte(key))
+	})
+}
+
+// LoadClicks implements ClickStore.
+func (st *BoltStore) LoadClicks() ([]Click, error) {
+	var clicks []Click
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketClicks).ForEach(func(k, v []byte) error {
+			var c Click
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("error decoding clicks %q: %w", k, err)
+			}
+			clicks = append(clicks, c)
+			return nil
+		})
+	})
+	return clicks, err
+}
+
+// AddClicks implements ClickStore.
+func (st *BoltStore) AddClicks(clicks []Click) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketClicks)
+		for _, c := range clicks {
+			key := []byte(strings.Join([]string{c.Day, c.Host, c.ShortPath, c.Referrer}, "\x00"))
+			if v := b.Get(key); v != nil {
+				var stored Click
+				if err := json.Unmarshal(v, &stored); err != nil {
+					return fmt.Errorf("error decoding clicks %q: %w", key, err)
+				}
+				c.Count += stored.Count
+			}
+			v, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the underlying database.
+func (st *BoltStore) Close() error {
+	return st.db.Close()
+}
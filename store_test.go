@@ -0,0 +1,226 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore exercises the basic operations of st, which must be empty.
+func testStore(t *testing.T, st Store) {
+	t.Helper()
+
+	a := Mapping{ShortPath: "/a", Permalink: "/1", Source: Source{Kind: SourceManual}}
+	a2 := Mapping{ShortPath: "/a", Permalink: "/3", Source: Source{Kind: SourceManual}}
+	b := Mapping{Host: "x.example", ShortPath: "/a", Permalink: "/2", Source: Source{Kind: SourceManual}}
+
+	steps := []struct {
+		name string
+		op   func() error
+		want []Mapping
+	}{
+		{"put a", func() error { return st.Put(a) }, []Mapping{a}},
+		{"put b", func() error { return st.Put(b) }, []Mapping{a, b}},
+		{"replace a", func() error { return st.Put(a2) }, []Mapping{a2, b}},
+		{"delete a", func() error { return st.Delete("", "/a") }, []Mapping{b}},
+		{"delete missing", func() error { return st.Delete("", "/missing") }, []Mapping{b}},
+		{"delete b", func() error { return st.Delete("X.example", "/a") }, nil},
+	}
+
+	for _, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatalf("%s returned error: %v", step.name, err)
+		}
+		got, err := st.Load()
+		if err != nil {
+			t.Fatalf("Load after %s returned error: %v", step.name, err)
+		}
+		if len(got) == 0 && len(step.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("after %s, Load returned %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	st, err := OpenBoltStore(filepath.Join(t.TempDir(), "gum.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore returned error: %v", err)
+	}
+	defer st.Close()
+	testStore(t, st)
+}
+
+func TestBoltStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gum.db")
+	m := Mapping{ShortPath: "/a", Permalink: "/1", Source: Source{Kind: SourceManual}}
+
+	st, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore returned error: %v", err)
+	}
+	if err := st.Put(m); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	st.Close()
+
+	st, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore returned error: %v", err)
+	}
+	defer st.Close()
+	if got, err := st.Load(); err != nil || !reflect.DeepEqual(got, []Mapping{m}) {
+		t.Errorf("Load after reopening returned %v, %v, want %v", got, err, []Mapping{m})
+	}
+}
+
+func TestServer_SetStore(t *testing.T) {
+	st := NewMemoryStore()
+	stored := Mapping{ShortPath: "/s", Permalink: "/stored", Source: Source{Kind: SourceManual}}
+	st.Put(stored)
+	st.Put(Mapping{ShortPath: "invalid", Permalink: "/x"})
+
+	g := NewServer()
+	static := Mapping{ShortPath: "/s", Permalink: "/static", Source: Source{Kind: SourceStatic}}
+	g.SetMapping(static)
+	if err := g.SetStore(st); err != nil {
+		t.Fatalf("SetStore returned error: %v", err)
+	}
+
+	// stored manual mappings take precedence over static mappings
+	if m, _ := g.Lookup("", "/s"); m != stored {
+		t.Errorf("Lookup(/s) returned %v, want %v", m, stored)
+	}
+	if _, ok := g.Lookup("", "invalid"); ok {
+		t.Errorf("invalid stored mapping was added")
+	}
+	st.Delete("", "invalid")
+
+	// manual mappings are persisted, others are not
+	manual := Mapping{ShortPath: "/m", Permalink: "/manual", Source: Source{Kind: SourceManual}}
+	if err := g.AddMapping(Mapping{ShortPath: "/m", Permalink: "/manual"}); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+	g.SetMapping(Mapping{ShortPath: "/c", Permalink: "/config", Source: Source{Kind: SourceConfig}})
+	if got, want := mustLoad(t, st), []Mapping{manual, stored}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored mappings are %v, want %v", got, want)
+	}
+
+	// removing the manual mapping leaves the static mapping in place
+	if err := g.removeMapping(stored); err != nil {
+		t.Fatalf("removeMapping returned error: %v", err)
+	}
+	if m, _ := g.Lookup("", "/s"); m != static {
+		t.Errorf("Lookup(/s) returned %v, want %v", m, static)
+	}
+	if err := g.RemoveMapping("", "/m"); err != nil {
+		t.Fatalf("RemoveMapping returned error: %v", err)
+	}
+	if got := mustLoad(t, st); len(got) != 0 {
+		t.Errorf("stored mappings are %v, want none", got)
+	}
+}
+
+func TestServer_SetStore_Tombstones(t *testing.T) {
+	st := NewMemoryStore()
+	now := time.Now()
+	kept := Mapping{ShortPath: "/k", Permalink: "/pk", Source: Source{Kind: SourceStatic}}.tombstone(now)
+	old := Mapping{ShortPath: "/o", Permalink: "/po", Source: Source{Kind: SourceStatic}}.tombstone(now.Add(-2 * time.Hour))
+	st.Put(kept)
+	st.Put(old)
+
+	g := NewServer()
+	g.SetTombstoneRetention(time.Hour)
+	if err := g.SetStore(st); err != nil {
+		t.Fatalf("SetStore returned error: %v", err)
+	}
+
+	// stored tombstones remain tombstones, and expired ones are dropped
+	if m, _ := g.Lookup("", "/k"); m != kept {
+		t.Errorf("Lookup(/k) returned %v, want %v", m, kept)
+	}
+	if got, want := mustLoad(t, st), []Mapping{kept}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored mappings are %v, want %v", got, want)
+	}
+
+	// new tombstones are persisted, unless a manual mapping is stored
+	if err := g.AddMapping(Mapping{ShortPath: "/m", Permalink: "/pm"}); err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+	manual, _ := g.Lookup("", "/m")
+	static := Mapping{ShortPath: "/s", Permalink: "/ps", Source: Source{Kind: SourceStatic}}
+	g.mappings <- static.tombstone(now)
+	g.mappings <- Mapping{ShortPath: "/m", Permalink: "/pm", Source: Source{Kind: SourceStatic}}.tombstone(now)
+	g.mappings <- Mapping{}
+	if got, want := mustLoad(t, st), []Mapping{kept, manual, static.tombstone(now)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored mappings are %v, want %v", got, want)
+	}
+}
+
+func mustLoad(t *testing.T, st Store) []Mapping {
+	t.Helper()
+	mappings, err := st.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	return mappings
+}
+
+// blockingStore is a Store whose Put blocks until release is closed.
+type blockingStore struct {
+	Store
+	putting chan struct{}
+	release chan struct{}
+}
+
+func (st *blockingStore) Put(m Mapping) error {
+	close(st.putting)
+	<-st.release
+	return st.Store.Put(m)
+}
+
+func TestServer_SetStore_Slow(t *testing.T) {
+	st := &blockingStore{
+		Store:   NewMemoryStore(),
+		putting: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	g := NewServer()
+	g.SetMapping(Mapping{ShortPath: "/a", Permalink: "/1"})
+	if err := g.SetStore(st); err != nil {
+		t.Fatalf("SetStore returned error: %v", err)
+	}
+
+	errc := make(chan error)
+	go func() { errc <- g.AddMapping(Mapping{ShortPath: "/b", Permalink: "/2"}) }()
+	<-st.putting
+
+	// lookups are not blocked by a pending store write
+	if _, ok := g.Lookup("", "/a"); !ok {
+		t.Errorf("Lookup(/a) returned no mapping during store write")
+	}
+	if _, ok := g.Lookup("", "/b"); ok {
+		t.Errorf("Lookup(/b) returned mapping before it was stored")
+	}
+
+	close(st.release)
+	if err := <-errc; err != nil {
+		t.Fatalf("AddMapping returned error: %v", err)
+	}
+	if _, ok := g.Lookup("", "/b"); !ok {
+		t.Errorf("Lookup(/b) returned no mapping after it was stored")
+	}
+}
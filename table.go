@@ -0,0 +1,396 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceKind identifies the kind of source a Mapping came from.  When
+// multiple sources map the same short URL, the mapping from the source with
+// the greatest SourceKind is used.
+type SourceKind int
+
+// Kinds of mapping sources, in increasing order of precedence, except that
+// tombstones never take precedence over a mapping from another source.
+const (
+	// SourceUnknown is used for mappings which do not identify their source.
+	SourceUnknown SourceKind = iota
+
+	// SourceTombstone is used for tombstones, which record that a short
+	// URL has been retired, such as when the static file that mapped it
+	// was deleted.  Tombstones are served with a 410 (Gone) status until
+	// they expire, unless another source, including an unknown one, maps
+	// the short URL again.
+	SourceTombstone
+
+	// SourceStatic is used for mappings parsed from static files.
+	SourceStatic
+
+	// SourceManual is used for mappings added with Server.AddMapping or
+	// the admin API.
+	SourceManual
+
+	// SourceConfig is used for mappings explicitly specified in
+	// configuration.
+	SourceConfig
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case SourceUnknown:
+		return "unknown"
+	case SourceTombstone:
+		return "tombstone"
+	case SourceStatic:
+		return "static"
+	case SourceManual:
+		return "manual"
+	case SourceConfig:
+		return "config"
+	}
+	return fmt.Sprintf("SourceKind(%d)", int(k))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k SourceKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *SourceKind) UnmarshalText(text []byte) error {
+	for _, kind := range []SourceKind{SourceUnknown, SourceTombstone, SourceStatic, SourceManual, SourceConfig} {
+		if string(text) == kind.String() {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("gum: unknown source kind %q", text)
+}
+
+// Source identifies where a Mapping came from.
+type Source struct {
+	// Kind is the kind of source.
+	Kind SourceKind `json:"kind"`
+
+	// Name identifies the handler that provided the mapping, such as the
+	// base directory of a StaticHandler.
+	Name string `json:"name,omitempty"`
+
+	// File is the file the mapping was read from, if any.
+	File string `json:"file,omitempty"`
+
+	// Modified is the modification time of File.  When multiple files map
+	// the same short URL, the most recently modified file is used.  For
+	// tombstones, Modified is the time the short URL was retired.
+	Modified time.Time `json:"modified"`
+}
+
+// MarshalJSON implements json.Marshaler, omitting Modified if it is zero.
+func (s Source) MarshalJSON() ([]byte, error) {
+	type source Source // prevent recursion
+	v := struct {
+		source
+		Modified *time.Time `json:"modified,omitempty"`
+	}{source: source(s)}
+	if !s.Modified.IsZero() {
+		v.Modified = &s.Modified
+	}
+	return json.Marshal(v)
+}
+
+func (s Source) String() string {
+	str := s.Kind.String()
+	if s.Name != "" {
+		str += " " + s.Name
+	}
+	if s.File != "" {
+		str += " (" + s.File + ")"
+	}
+	return str
+}
+
+// same reports whether s and t identify the same source, regardless of when
+// they were modified.
+func (s Source) same(t Source) bool {
+	return s.Kind == t.Kind && s.Name == t.Name && s.File == t.File
+}
+
+// outranks reports whether mapping a takes precedence over mapping b for the
+// same short URL.  Tombstones rank below all other mappings, including those
+// from handlers which do not set a source.  Otherwise, mappings are ranked by
+// source kind, then by modification time (newest first), then by source name
+// and file so that the ranking is always deterministic.
+func outranks(a, b Mapping) bool {
+	if a.isTombstone() != b.isTombstone() {
+		return b.isTombstone()
+	}
+	as, bs := a.Source, b.Source
+	if as.Kind != bs.Kind {
+		return as.Kind > bs.Kind
+	}
+	if !as.Modified.Equal(bs.Modified) {
+		return as.Modified.After(bs.Modified)
+	}
+	if as.Name != bs.Name {
+		return as.Name < bs.Name
+	}
+	return as.File < bs.File
+}
+
+// A Conflict describes a short URL which multiple sources map to different
+// permalinks.
+type Conflict struct {
+	// Mapping is the mapping used to serve the short URL.
+	Mapping Mapping `json:"mapping"`
+
+	// Overridden are the conflicting mappings from sources with lower
+	// precedence, in decreasing order of precedence.
+	Overridden []Mapping `json:"overridden"`
+}
+
+func (c Conflict) String() string {
+	s := fmt.Sprintf("%v => %v from %v", c.Mapping.key(), c.Mapping.Permalink, c.Mapping.Source)
+	for _, m := range c.Overridden {
+		s += fmt.Sprintf("; overrides %v from %v", m.Permalink, m.Source)
+	}
+	return s
+}
+
+// table is a set of mappings, keyed by short URL as returned by Mapping.key.
+// Each short URL may be mapped by several sources, which are stored in order
+// of precedence.  The first mapping for each short URL is the one used to
+// serve requests.
+type table struct {
+	urls map[string][]Mapping
+
+	// permalinks indexes the keys of the short URLs which redirect to
+	// each permalink, as returned by permalinkKey.
+	permalinks map[string]map[string]bool
+}
+
+// newTable returns an empty table.
+func newTable() *table {
+	return &table{
+		urls:       make(map[string][]Mapping),
+		permalinks: make(map[string]map[string]bool),
+	}
+}
+
+// get returns the highest precedence mapping for key.
+func (t *table) get(key string) (Mapping, bool) {
+	if ms := t.urls[key]; len(ms) > 0 {
+		return ms[0], true
+	}
+	return Mapping{}, false
+}
+
+// put replaces the mappings for key with ms, which must be in order of
+// precedence, and updates the permalink index.  If ms is empty, key is
+// removed.
+func (t *table) put(key string, ms []Mapping) {
+	if old, ok := t.get(key); ok && old.redirects() {
+		pk := permalinkKey(old.Permalink)
+		delete(t.permalinks[pk], key)
+		if len(t.permalinks[pk]) == 0 {
+			delete(t.permalinks, pk)
+		}
+	}
+	if len(ms) == 0 {
+		delete(t.urls, key)
+		return
+	}
+	t.urls[key] = ms
+	if ms[0].redirects() {
+		pk := permalinkKey(ms[0].Permalink)
+		if t.permalinks[pk] == nil {
+			t.permalinks[pk] = make(map[string]bool)
+		}
+		t.permalinks[pk][key] = true
+	}
+}
+
+// set adds m to t, replacing any existing mapping for the same short URL from
+// the same source.
+func (t *table) set(m Mapping) {
+	key := m.key()
+	old, exists := t.get(key)
+
+	// copy, since put compares against the existing slice
+	ms := make([]Mapping, 0, len(t.urls[key])+1)
+	for _, old := range t.urls[key] {
+		if !old.Source.same(m.Source) {
+			ms = append(ms, old)
+		}
+	}
+	ms = append(ms, m)
+	sort.SliceStable(ms, func(i, j int) bool { return outranks(ms[i], ms[j]) })
+	t.put(key, ms)
+
+	cur := ms[0]
+	switch {
+	case cur.isTombstone():
+		if !exists || !old.isTombstone() {
+			log.Printf("Retiring mapping: %v", key)
+		}
+	case !exists:
+		log.Printf("New mapping: %-7v => %v", key, cur.Permalink)
+	case !cur.sameTarget(old):
+		log.Printf("Overwriting mapping: %v => %v (previously %q)", key, cur.Permalink, old.Permalink)
+	}
+	if !cur.sameTarget(m) && !m.isTombstone() {
+		log.Printf("Conflicting mapping: %v => %v from %v is overridden by %v from %v",
+			key, m.Permalink, m.Source, cur.Permalink, cur.Source)
+	}
+}
+
+// delete removes the mapping for the short URL of m from the source of m,
+// reporting whether it existed.
+func (t *table) delete(m Mapping) bool {
+	key := m.key()
+	ms := t.urls[key]
+	for i := range ms {
+		if ms[i].Source.same(m.Source) {
+			old := ms[0]
+			// copy, since put compares against the existing slice
+			kept := append(append([]Mapping(nil), ms[:i]...), ms[i+1:]...)
+			t.put(key, kept)
+			switch {
+			case len(kept) == 0:
+				log.Printf("Deleting mapping: %v", key)
+			case kept[0].isTombstone() && !old.isTombstone():
+				log.Printf("Retiring mapping: %v", key)
+			case !kept[0].sameTarget(old):
+				log.Printf("Overwriting mapping: %v => %v (previously %q)", key, kept[0].Permalink, old.Permalink)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// deleteKey removes the mappings for key from all sources, reporting whether
+// any existed.
+func (t *table) deleteKey(key string) bool {
+	if _, exists := t.urls[key]; !exists {
+		return false
+	}
+	log.Printf("Deleting mapping: %v", key)
+	t.put(key, nil)
+	return true
+}
+
+// mappings returns the highest precedence mapping for each short URL in t,
+// sorted by key.
+func (t *table) mappings() []Mapping {
+	mappings := make([]Mapping, 0, len(t.urls))
+	for _, ms := range t.urls {
+		mappings = append(mappings, ms[0])
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].key() < mappings[j].key()
+	})
+	return mappings
+}
+
+// conflicts returns the short URLs in t that are mapped to different
+// permalinks by different sources, sorted by key.  Tombstones are not
+// considered conflicts.
+func (t *table) conflicts() []Conflict {
+	var conflicts []Conflict
+	for _, ms := range t.urls {
+		c := Conflict{Mapping: ms[0]}
+		for _, m := range ms[1:] {
+			if !m.sameTarget(c.Mapping) && !m.isTombstone() {
+				c.Overridden = append(c.Overridden, m)
+			}
+		}
+		if len(c.Overridden) > 0 {
+			conflicts = append(conflicts, c)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Mapping.key() < conflicts[j].Mapping.key()
+	})
+	return conflicts
+}
+
+// expire removes tombstones in t which were retired before cutoff, returning
+// the removed tombstones.
+func (t *table) expire(cutoff time.Time) []Mapping {
+	var expired []Mapping
+	for key, ms := range t.urls {
+		var kept []Mapping
+		for _, m := range ms {
+			if m.isTombstone() && m.Source.Modified.Before(cutoff) {
+				expired = append(expired, m)
+			} else {
+				kept = append(kept, m)
+			}
+		}
+		if len(kept) == len(ms) {
+			continue
+		}
+		log.Printf("Expiring tombstone: %v", key)
+		t.put(key, kept)
+	}
+	return expired
+}
+
+// hasKind reports whether any source of kind k maps key.
+func (t *table) hasKind(key string, k SourceKind) bool {
+	for _, m := range t.urls[key] {
+		if m.Source.Kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// kind returns all mappings in t from sources of kind k.
+func (t *table) kind(k SourceKind) []Mapping {
+	var mappings []Mapping
+	for _, ms := range t.urls {
+		for _, m := range ms {
+			if m.Source.Kind == k {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	return mappings
+}
+
+// permalink returns the highest precedence mapping of each short URL which
+// redirects to permalink, sorted by key.
+func (t *table) permalink(permalink string) []Mapping {
+	keys := t.permalinks[permalinkKey(permalink)]
+	mappings := make([]Mapping, 0, len(keys))
+	for key := range keys {
+		mappings = append(mappings, t.urls[key][0])
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].key() < mappings[j].key()
+	})
+	return mappings
+}
+
+// permalinkKey returns the key used to index permalink, ignoring the case of
+// its scheme and host and any fragment.
+func permalinkKey(permalink string) string {
+	u, err := url.Parse(permalink)
+	if err != nil {
+		return permalink
+	}
+	u.Scheme, u.Host, u.Fragment = strings.ToLower(u.Scheme), strings.ToLower(u.Host), ""
+	return u.String()
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Whistle computes shortlinks for static pages using Tantek Çelik's Whistle
+// scheme, in which a shortlink consists of a content type prefix, the date the
+// page was published as three NewBase60 digits counting days since
+// 1970-01-01, and the NewBase60 ordinal of the page among those of the same
+// type published that day.  For example, the second note published on
+// 2014-02-21 would have the shortlink "/t4Uh2".
+// See http://tantek.com/w/Whistle
+type Whistle struct {
+	// Types maps content type prefixes, such as "t" or "b", to the path
+	// prefix of the permalinks of that type, such as "/notes/".  A page
+	// has the type with the longest path prefix its permalink matches.
+	// Pages which match no type do not get a Whistle shortlink.
+	Types map[string]string
+
+	// Host restricts the computed shortlinks to a single host.  By
+	// default, they apply to requests for any host.
+	Host string
+}
+
+// Validate returns an error if w is not a usable Whistle configuration.
+func (w *Whistle) Validate() error {
+	if len(w.Types) == 0 {
+		return fmt.Errorf("gum: whistle should specify at least one content type")
+	}
+	for typ, prefix := range w.Types {
+		if typ == "" || strings.ContainsAny(typ, "/?#") {
+			return fmt.Errorf("gum: whistle content type %q should be a non-empty path segment", typ)
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("gum: whistle path prefix %q for type %q should begin with a slash", prefix, typ)
+		}
+	}
+	if strings.Contains(w.Host, "/") {
+		return fmt.Errorf("gum: whistle host %q should not contain a slash", w.Host)
+	}
+	return nil
+}
+
+// Shortlink returns the Whistle short path for the ordinal (counting from 1)
+// page of type typ published at t.  Dates are taken in the location of t.
+func (w *Whistle) Shortlink(typ string, t time.Time, ordinal int) string {
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+	day := EncodeNewBase60(int(days))
+	if len(day) < 3 {
+		day = strings.Repeat("0", 3-len(day)) + day
+	}
+	return "/" + typ + day + EncodeNewBase60(ordinal)
+}
+
+// typeOf returns the content type of the page with the specified permalink.
+func (w *Whistle) typeOf(permalink string) (string, bool) {
+	u, err := url.Parse(permalink)
+	if err != nil {
+		return "", false
+	}
+	var typ, prefix string
+	for t, p := range w.Types {
+		if strings.HasPrefix(u.Path, p) && (len(p) > len(prefix) || len(p) == len(prefix) && t < typ) {
+			typ, prefix = t, p
+		}
+	}
+	return typ, typ != ""
+}
+
+// mappings returns the Whistle mappings for pages, which are keyed by file
+// path.  Pages without a permalink or publication date are skipped.  The source of each
+// mapping is returned by source.
+func (w *Whistle) mappings(pages map[string]page, source func(file string) Source) []Mapping {
+	type entry struct {
+		file, typ string
+		page      page
+	}
+	// pages grouped by type and day published
+	days := make(map[string][]entry)
+	for file, p := range pages {
+		if p.permalink == "" || p.published.IsZero() {
+			continue
+		}
+		typ, ok := w.typeOf(p.permalink)
+		if !ok {
+			continue
+		}
+		key := typ + "/" + p.published.Format("2006-01-02")
+		days[key] = append(days[key], entry{file, typ, p})
+	}
+
+	var mappings []Mapping
+	for _, entries := range days {
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].page, entries[j].page
+			if !a.published.Equal(b.published) {
+				return a.published.Before(b.published)
+			}
+			return a.permalink < b.permalink
+		})
+		for i, e := range entries {
+			mappings = append(mappings, Mapping{
+				Host:      w.Host,
+				ShortPath: w.Shortlink(e.typ, e.page.published, i+1),
+				Permalink: e.page.permalink,
+				Source:    source(e.file),
+				Title:     e.page.title,
+			})
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].key() < mappings[j].key()
+	})
+	return mappings
+}
+
+// EncodeNewBase60 returns the NewBase60 representation of n, which must not
+// be negative.
+func EncodeNewBase60(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	var s []byte
+	for ; n > 0; n /= 60 {
+		s = append([]byte{NewBase60[n%60]}, s...)
+	}
+	return string(s)
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/BSD-3-Clause
+
+package gum
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeNewBase60(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{10, "A"},
+		{34, "_"},
+		{59, "z"},
+		{60, "10"},
+		{16122, "4Uh"},
+	}
+	for _, tt := range tests {
+		if got := EncodeNewBase60(tt.n); got != tt.want {
+			t.Errorf("EncodeNewBase60(%d) returned %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWhistle_Shortlink(t *testing.T) {
+	w := &Whistle{}
+	pst := time.FixedZone("PST", -8*60*60)
+	tests := []struct {
+		typ     string
+		t       time.Time
+		ordinal int
+		want    string
+	}{
+		{"t", time.Date(2014, 2, 21, 12, 0, 0, 0, time.UTC), 2, "/t4Uh2"},
+		{"b", time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), 1, "/b0011"},
+		{"t", time.Date(2014, 2, 21, 23, 0, 0, 0, pst), 61, "/t4Uh11"},
+	}
+	for _, tt := range tests {
+		if got := w.Shortlink(tt.typ, tt.t, tt.ordinal); got != tt.want {
+			t.Errorf("Shortlink(%q, %v, %d) returned %q, want %q", tt.typ, tt.t, tt.ordinal, got, tt.want)
+		}
+	}
+}
+
+func TestWhistle_Validate(t *testing.T) {
+	tests := []struct {
+		w     Whistle
+		valid bool
+	}{
+		{Whistle{Types: map[string]string{"t": "/notes/"}}, true},
+		{Whistle{Types: map[string]string{"t": "/notes/"}, Host: "x.example"}, true},
+		{Whistle{}, false},
+		{Whistle{Types: map[string]string{"": "/notes/"}}, false},
+		{Whistle{Types: map[string]string{"t/": "/notes/"}}, false},
+		{Whistle{Types: map[string]string{"t": "notes/"}}, false},
+		{Whistle{Types: map[string]string{"t": "/notes/"}, Host: "x.example/"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.w.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v.Validate() returned %v, want valid %v", tt.w, err, tt.valid)
+		}
+	}
+}
+
+func TestWhistle_Mappings(t *testing.T) {
+	w := &Whistle{Types: map[string]string{"b": "/", "t": "/notes/"}}
+	day := time.Date(2014, 2, 21, 0, 0, 0, 0, time.UTC)
+	pages := map[string]page{
+		"note2.html": {permalink: "http://example.com/notes/2", published: day.Add(2 * time.Hour)},
+		"note1.html": {permalink: "http://example.com/notes/1", published: day.Add(time.Hour)},
+		"post.html":  {permalink: "http://example.com/post", published: day.Add(3 * time.Hour)},
+		"old.html":   {permalink: "/notes/old", published: day.Add(-time.Hour)},
+		"draft.html": {permalink: "/notes/draft"},
+		"none.html":  {published: day},
+	}
+	source := func(file string) Source { return Source{Kind: SourceStatic, File: file} }
+
+	got := w.mappings(pages, source)
+	want := []Mapping{
+		{ShortPath: "/b4Uh1", Permalink: "http://example.com/post", Source: source("post.html")},
+		{ShortPath: "/t4Ug1", Permalink: "/notes/old", Source: source("old.html")},
+		{ShortPath: "/t4Uh1", Permalink: "http://example.com/notes/1", Source: source("note1.html")},
+		{ShortPath: "/t4Uh2", Permalink: "http://example.com/notes/2", Source: source("note2.html")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings returned %v, want %v", got, want)
+	}
+}
+
+func TestStaticHandler_Whistle(t *testing.T) {
+	base := t.TempDir()
+	files := map[string]string{
+		"a.html": `<link rel="canonical" href="/notes/a"><time class="dt-published" datetime="2014-02-21T10:00:00Z">`,
+		"b.html": `<link rel="canonical" href="/notes/b"><meta property="article:published_time" content="2014-02-21T09:00:00Z">` +
+			`<link rel="shortlink" href="/t4Uh1">`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(base, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := NewStaticHandler(base)
+	if err != nil {
+		t.Fatalf("NewStaticHandler returned error: %v", err)
+	}
+	h.Whistle = &Whistle{Types: map[string]string{"t": "/notes/"}}
+
+	g := NewServer()
+	if err := g.AddHandler(h); err != nil {
+		t.Fatalf("AddHandler returned error: %v", err)
+	}
+	defer g.Close()
+
+	tests := []struct {
+		path, permalink string
+	}{
+		{"/t4Uh1", "/notes/b"},
+		{"/t4Uh2", "/notes/a"},
+	}
+	for _, tt := range tests {
+		m, ok := g.Lookup("", tt.path)
+		if !ok || m.Permalink != tt.permalink {
+			t.Errorf("Lookup(%q) returned %v, want permalink %q", tt.path, m, tt.permalink)
+		}
+	}
+	if conflicts := g.Conflicts(); len(conflicts) != 0 {
+		t.Errorf("Conflicts returned %v, want none", conflicts)
+	}
+}